@@ -0,0 +1,66 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (r *ClusterIssuer) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-azure-issuer-microsoft-com-v1alpha1-clusterissuer,mutating=true,failurePolicy=fail,sideEffects=None,groups=azure-issuer.microsoft.com,resources=clusterissuers,verbs=create;update,versions=v1alpha1,name=mclusterissuer.azure-issuer.microsoft.com
+
+var _ webhook.Defaulter = &ClusterIssuer{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *ClusterIssuer) Default() {
+	r.Spec.setDefaults()
+}
+
+// +kubebuilder:webhook:path=/validate-azure-issuer-microsoft-com-v1alpha1-clusterissuer,mutating=false,failurePolicy=fail,sideEffects=None,groups=azure-issuer.microsoft.com,resources=clusterissuers,verbs=create;update,versions=v1alpha1,name=vclusterissuer.azure-issuer.microsoft.com
+
+var _ webhook.Validator = &ClusterIssuer{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterIssuer) ValidateCreate() error {
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterIssuer) ValidateUpdate(old runtime.Object) error {
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ClusterIssuer) ValidateDelete() error {
+	return nil
+}
+
+func (r *ClusterIssuer) validate() error {
+	if errs := r.Spec.validate(field.NewPath("spec")); len(errs) > 0 {
+		return apierrors.NewInvalid(GroupVersion.WithKind("ClusterIssuer").GroupKind(), r.Name, errs)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The helpers below interpret the IssuerSpec the same way for the webhooks and
+// the signers, so an issuer the webhook accepts is configured as validated.
+
+// DefaultCertificateNameTemplate names keyvault certificates after the
+// cluster ID of the controller, if set, and the namespace and name of the
+// CertificateRequest, so clusters sharing a vault never use the same name.
+// Namespaces can't contain dots, so the rendered names are unique as long as
+// the cluster ID doesn't contain dots either.
+const DefaultCertificateNameTemplate = "{{ with .ClusterID }}{{ . }}.{{ end }}{{ .Namespace }}.{{ .Name }}"
+
+// key types of KeyProperties
+const (
+	keyTypeRSA    = "RSA"
+	keyTypeRSAHSM = "RSA-HSM"
+	keyTypeEC     = "EC"
+	keyTypeECHSM  = "EC-HSM"
+)
+
+// vaultNamePattern matches the names keyvault allows for vaults and Managed
+// HSMs, see https://docs.microsoft.com/en-us/azure/key-vault/about-keys-secrets-and-certificates#objects-identifiers-and-versioning
+var vaultNamePattern = regexp.MustCompile(`^[-A-Za-z0-9]{3,24}$`)
+
+// BackendName returns the backend selected by the issuer. Issuers without a
+// backend use the key-based CA when a CA certificate is set, the keyvault
+// certificate issuer otherwise.
+func (s *IssuerSpec) BackendName() string {
+	switch {
+	case s.Backend != "":
+		return s.Backend
+	case s.CACertificateName != "":
+		return BackendKeyVaultCA
+	default:
+		return BackendKeyVault
+	}
+}
+
+// ValidateVaultName checks the name is a valid vault or Managed HSM name
+func ValidateVaultName(name string) error {
+	if !vaultNamePattern.MatchString(name) {
+		return errors.New("must be 3 to 24 characters matching [-a-zA-Z0-9]")
+	}
+	return nil
+}
+
+// CertificateNameParameters are the values available in certificate name
+// templates
+// +kubebuilder:object:generate=false
+type CertificateNameParameters struct {
+	// Namespace is the namespace of the CertificateRequest
+	Namespace string
+	// Name is the name of the CertificateRequest
+	Name string
+	// UID is the UID of the CertificateRequest
+	UID string
+	// ClusterID identifies the cluster the controller runs in
+	ClusterID string
+}
+
+// ParseCertificateNameTemplate parses the certificate name template, an empty
+// template is DefaultCertificateNameTemplate. Executing the template fails
+// for fields that aren't CertificateNameParameters.
+func ParseCertificateNameTemplate(nameTemplate string) (*template.Template, error) {
+	if nameTemplate == "" {
+		nameTemplate = DefaultCertificateNameTemplate
+	}
+	tmpl, err := template.New("certificateName").Option("missingkey=error").Parse(nameTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate name template %q: %v", nameTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// ValidateCertificateNameTemplate parses the certificate name template and
+// renders it with sample parameters, so templates referring to unknown fields
+// are rejected
+func ValidateCertificateNameTemplate(nameTemplate string) error {
+	tmpl, err := ParseCertificateNameTemplate(nameTemplate)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(ioutil.Discard, CertificateNameParameters{
+		Namespace: "namespace",
+		Name:      "name",
+		UID:       "00000000-0000-0000-0000-000000000000",
+		ClusterID: "cluster",
+	}); err != nil {
+		return fmt.Errorf("failed to render certificate name template: %v", err)
+	}
+	return nil
+}
+
+// Validate checks the key size and curve agree with the key type. Without a
+// key type, they must agree with each other, as the key type of the CSR is
+// used.
+func (k *KeyProperties) Validate(fldPath *field.Path) field.ErrorList {
+	if k == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	switch k.KeyType {
+	case keyTypeRSA, keyTypeRSAHSM:
+		if k.Curve != "" {
+			errs = append(errs, field.Forbidden(fldPath.Child("curve"), fmt.Sprintf("can't be set for key type %s", k.KeyType)))
+		}
+	case keyTypeEC, keyTypeECHSM:
+		if k.KeySize != nil {
+			errs = append(errs, field.Forbidden(fldPath.Child("keySize"), fmt.Sprintf("can't be set for key type %s", k.KeyType)))
+		}
+	case "":
+		if k.KeySize != nil && k.Curve != "" {
+			errs = append(errs, field.Forbidden(fldPath.Child("curve"), "keySize and curve are mutually exclusive"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("keyType"), k.KeyType, []string{keyTypeRSA, keyTypeRSAHSM, keyTypeEC, keyTypeECHSM}))
+	}
+	if k.KeySize != nil && *k.KeySize <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("keySize"), *k.KeySize, "must be positive"))
+	}
+	return errs
+}
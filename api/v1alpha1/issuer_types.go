@@ -17,16 +17,38 @@ limitations under the License.
 package v1alpha1
 
 import (
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Signer backends of an issuer
+const (
+	// BackendKeyVault issues certificates with a keyvault certificate issuer
+	BackendKeyVault = "KeyVault"
+	// BackendKeyVaultCA signs certificates with the key of a CA certificate in keyvault
+	BackendKeyVaultCA = "KeyVaultCA"
+	// BackendManagedHSM signs certificates with a key in a Managed HSM
+	BackendManagedHSM = "ManagedHSM"
+)
+
 // IssuerSpec defines the desired state of Issuer
 type IssuerSpec struct {
-	// KeyvaultName is the vault name in which issuer exists
+	// KeyvaultName is the vault name in which issuer exists, or the name of
+	// the Managed HSM for the ManagedHSM backend
 	KeyvaultName string `json:"keyvaultName"`
 
+	// Backend selects how certificates are signed. KeyVault issues
+	// certificates with the keyvault issuer IssuerName, KeyVaultCA signs them
+	// with the key of the CA certificate CACertificateName and ManagedHSM
+	// signs them with the Managed HSM key configured in ManagedHSM. Defaults
+	// to KeyVaultCA if CACertificateName is set, KeyVault otherwise.
+	// +kubebuilder:validation:Enum=KeyVault;KeyVaultCA;ManagedHSM
+	// +optional
+	Backend string `json:"backend,omitempty"`
+
 	// IssuerName is the name of the issuer to use
-	IssuerName string `json:"issuerName"`
+	// +optional
+	IssuerName string `json:"issuerName,omitempty"`
 	// A reference to a Secret in the same namespace as the referent. If the
 	// referent is a ClusterIssuer, the reference instead refers to the resource
 	// with the given name in the configured 'cluster resource namespace', which
@@ -35,7 +57,137 @@ type IssuerSpec struct {
 	AuthSecretName string `json:"authSecretName"`
 	// IsSelfSigned is set to true if the issuer is for a self-signed certificate
 	// from keyvault.
-	IsSelfSigned bool `json:"isSelfSigned"`
+	// +optional
+	IsSelfSigned bool `json:"isSelfSigned,omitempty"`
+	// CACertificateName is the name of a certificate in the vault whose key is
+	// used to sign certificate requests. When set, the certificate is built
+	// locally from the CSR and only the signature is computed by keyvault, so
+	// the issued certificate is bound to the public key in the CSR.
+	// +optional
+	CACertificateName string `json:"caCertificateName,omitempty"`
+	// MinValidityInMonths is the minimum validity of issued certificates.
+	// Requested durations are rounded up to whole months of 30 days, shorter
+	// durations are raised to this minimum.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinValidityInMonths *int32 `json:"minValidityInMonths,omitempty"`
+	// MaxValidityInMonths is the maximum validity of issued certificates.
+	// CertificateRequests for longer durations are failed.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxValidityInMonths *int32 `json:"maxValidityInMonths,omitempty"`
+	// KeyProperties defines the key pair of certificates created in keyvault.
+	// The key type, size and curve default to the ones of the CSR and must
+	// agree with the CSR when set.
+	// +optional
+	KeyProperties *KeyProperties `json:"keyProperties,omitempty"`
+	// CertificateNameTemplate is a Go template for the name of certificates
+	// created in keyvault. The fields .Namespace, .Name and .UID of the
+	// CertificateRequest and the .ClusterID set on the controller can be used.
+	// Defaults to `{{ with .ClusterID }}{{ . }}.{{ end }}{{ .Namespace }}.{{ .Name }}`.
+	// Characters keyvault doesn't allow are replaced and a hash of the
+	// rendered name is appended to keep the names unique.
+	// +optional
+	CertificateNameTemplate string `json:"certificateNameTemplate,omitempty"`
+	// ManagedHSM configures the CA key used by the ManagedHSM backend.
+	// +optional
+	ManagedHSM *ManagedHSMSpec `json:"managedHSM,omitempty"`
+	// Policy restricts the certificates the issuer signs. CertificateRequests
+	// violating the policy are failed before they are sent to keyvault.
+	// +optional
+	Policy *RequestPolicy `json:"policy,omitempty"`
+}
+
+// RequestPolicy restricts the CertificateRequests an issuer signs. The
+// subject alternative names are taken from the CSR. A type of subject
+// alternative name is only allowed if the policy lists allowed values for it,
+// so URI, email address and user principal name SANs are always refused.
+type RequestPolicy struct {
+	// AllowedDNSNames are the DNS names certificates can be issued for. Each
+	// label of a name is matched against the label of the pattern at the same
+	// position with shell-style globs, e.g. `*.example.com` matches
+	// `www.example.com` but neither `example.com` nor `a.b.example.com`. A
+	// common name is checked as a DNS name, or as an IP address if it is one.
+	// +optional
+	AllowedDNSNames []string `json:"allowedDNSNames,omitempty"`
+	// AllowWildcardNames allows wildcard DNS names such as `*.example.com`.
+	// The wildcard must be the first label of the name and only matches a
+	// pattern whose label at the same position is `*`. Names containing `*`
+	// are refused otherwise.
+	// +optional
+	AllowWildcardNames bool `json:"allowWildcardNames,omitempty"`
+	// AllowedIPRanges are the CIDRs of the IP addresses certificates can be
+	// issued for, e.g. `10.0.0.0/8`.
+	// +optional
+	AllowedIPRanges []string `json:"allowedIPRanges,omitempty"`
+	// AllowedUsages are the key usages CertificateRequests can request. All
+	// usages are allowed if empty. CertificateRequests without usages request
+	// `digital signature` and `key encipherment`, CA certificates also
+	// request `cert sign`.
+	// +optional
+	AllowedUsages []cmapi.KeyUsage `json:"allowedUsages,omitempty"`
+	// MaxDuration is the longest validity of issued certificates.
+	// CertificateRequests without a duration request the cert-manager
+	// default of 90 days. The KeyVault backend issues certificates for whole
+	// calendar months, so its certificates are checked as valid for 31 days
+	// per month, e.g. a request for 90 days is issued for up to 93 days.
+	// +optional
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
+	// RequiredSubjectFields are the subject fields the CSR must set.
+	// +optional
+	RequiredSubjectFields []SubjectField `json:"requiredSubjectFields,omitempty"`
+}
+
+// SubjectField is a field of the subject of a certificate
+// +kubebuilder:validation:Enum=commonName;organizations;organizationalUnits;countries;provinces;localities;streetAddresses;postalCodes;serialNumber
+type SubjectField string
+
+// Subject fields a RequestPolicy can require
+const (
+	SubjectCommonName          SubjectField = "commonName"
+	SubjectOrganizations       SubjectField = "organizations"
+	SubjectOrganizationalUnits SubjectField = "organizationalUnits"
+	SubjectCountries           SubjectField = "countries"
+	SubjectProvinces           SubjectField = "provinces"
+	SubjectLocalities          SubjectField = "localities"
+	SubjectStreetAddresses     SubjectField = "streetAddresses"
+	SubjectPostalCodes         SubjectField = "postalCodes"
+	SubjectSerialNumber        SubjectField = "serialNumber"
+)
+
+// ManagedHSMSpec defines a CA key stored in a Managed HSM. Managed HSM only
+// stores keys, so the CA certificate of the key is kept on the issuer.
+type ManagedHSMSpec struct {
+	// KeyName is the name of the key in the Managed HSM that signs certificates.
+	KeyName string `json:"keyName"`
+	// KeyVersion is the version of the key. Defaults to the current version.
+	// +optional
+	KeyVersion string `json:"keyVersion,omitempty"`
+	// CACertificate is the PEM encoded CA certificate of the key, optionally
+	// followed by the certificates of its issuers. The CA certificate must
+	// certify the public key of the key.
+	CACertificate string `json:"caCertificate"`
+}
+
+// KeyProperties defines the key pair backing a certificate created in keyvault
+type KeyProperties struct {
+	// KeyType is the type of the key pair.
+	// +kubebuilder:validation:Enum=RSA;RSA-HSM;EC;EC-HSM
+	// +optional
+	KeyType string `json:"keyType,omitempty"`
+	// KeySize is the size in bits of RSA keys, e.g. 2048, 3072 or 4096.
+	// +optional
+	KeySize *int32 `json:"keySize,omitempty"`
+	// Curve is the elliptic curve of EC keys.
+	// +kubebuilder:validation:Enum=P-256;P-256K;P-384;P-521
+	// +optional
+	Curve string `json:"curve,omitempty"`
+	// Exportable is set if the private key can be exported from keyvault.
+	// +optional
+	Exportable *bool `json:"exportable,omitempty"`
+	// ReuseKey is set if the key pair is reused when the certificate is renewed.
+	// +optional
+	ReuseKey *bool `json:"reuseKey,omitempty"`
 }
 
 // IssuerStatus defines the observed state of Issuer
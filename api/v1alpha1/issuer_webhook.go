@@ -0,0 +1,173 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"path"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (r *Issuer) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-azure-issuer-microsoft-com-v1alpha1-issuer,mutating=true,failurePolicy=fail,sideEffects=None,groups=azure-issuer.microsoft.com,resources=issuers,verbs=create;update,versions=v1alpha1,name=missuer.azure-issuer.microsoft.com
+
+var _ webhook.Defaulter = &Issuer{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Issuer) Default() {
+	r.Spec.setDefaults()
+}
+
+// +kubebuilder:webhook:path=/validate-azure-issuer-microsoft-com-v1alpha1-issuer,mutating=false,failurePolicy=fail,sideEffects=None,groups=azure-issuer.microsoft.com,resources=issuers,verbs=create;update,versions=v1alpha1,name=vissuer.azure-issuer.microsoft.com
+
+var _ webhook.Validator = &Issuer{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Issuer) ValidateCreate() error {
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Issuer) ValidateUpdate(old runtime.Object) error {
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Issuer) ValidateDelete() error {
+	return nil
+}
+
+func (r *Issuer) validate() error {
+	if errs := r.Spec.validate(field.NewPath("spec")); len(errs) > 0 {
+		return apierrors.NewInvalid(GroupVersion.WithKind("Issuer").GroupKind(), r.Name, errs)
+	}
+	return nil
+}
+
+// setDefaults sets the optional fields whose defaults are otherwise applied
+// by the signer, so the effective configuration is visible on the issuer. The
+// backend is left empty, it is derived from caCertificateName until it is set.
+func (s *IssuerSpec) setDefaults() {
+	if s.CertificateNameTemplate == "" {
+		s.CertificateNameTemplate = DefaultCertificateNameTemplate
+	}
+}
+
+// validate checks the issuer configuration that doesn't depend on the vault or
+// the auth Secret. Whether the vault and its objects exist and the credentials
+// can access them is checked by the issuer controller.
+func (s *IssuerSpec) validate(fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if err := ValidateVaultName(s.KeyvaultName); err != nil {
+		errs = append(errs, field.Invalid(fldPath.Child("keyvaultName"), s.KeyvaultName, err.Error()))
+	}
+	if s.AuthSecretName == "" {
+		errs = append(errs, field.Required(fldPath.Child("authSecretName"), ""))
+	}
+	if s.IssuerName != "" && s.IsSelfSigned {
+		errs = append(errs, field.Forbidden(fldPath.Child("isSelfSigned"), "issuerName and isSelfSigned are mutually exclusive"))
+	}
+
+	backend := s.BackendName()
+	switch backend {
+	case BackendKeyVault:
+		if s.IssuerName == "" && !s.IsSelfSigned {
+			errs = append(errs, field.Required(fldPath.Child("issuerName"), "required by the KeyVault backend unless isSelfSigned is set"))
+		}
+	case BackendKeyVaultCA:
+		if s.CACertificateName == "" {
+			errs = append(errs, field.Required(fldPath.Child("caCertificateName"), "required by the KeyVaultCA backend"))
+		}
+	case BackendManagedHSM:
+		errs = append(errs, s.ManagedHSM.validate(fldPath.Child("managedHSM"))...)
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("backend"), s.Backend, []string{BackendKeyVault, BackendKeyVaultCA, BackendManagedHSM}))
+	}
+	if s.CACertificateName != "" && backend != BackendKeyVaultCA {
+		errs = append(errs, field.Forbidden(fldPath.Child("caCertificateName"), "only used by the KeyVaultCA backend"))
+	}
+	if err := ValidateCertificateNameTemplate(s.CertificateNameTemplate); err != nil {
+		errs = append(errs, field.Invalid(fldPath.Child("certificateNameTemplate"), s.CertificateNameTemplate, err.Error()))
+	}
+	errs = append(errs, s.KeyProperties.Validate(fldPath.Child("keyProperties"))...)
+
+	if s.MinValidityInMonths != nil && s.MaxValidityInMonths != nil && *s.MinValidityInMonths > *s.MaxValidityInMonths {
+		errs = append(errs, field.Invalid(fldPath.Child("minValidityInMonths"), *s.MinValidityInMonths, "must not be greater than maxValidityInMonths"))
+	}
+	if s.Policy != nil {
+		errs = append(errs, s.Policy.validate(fldPath.Child("policy"))...)
+	}
+	return errs
+}
+
+// validate checks the key and CA certificate of the ManagedHSM backend are set
+// and the CA certificate can be parsed
+func (m *ManagedHSMSpec) validate(fldPath *field.Path) field.ErrorList {
+	if m == nil {
+		return field.ErrorList{field.Required(fldPath, "required by the ManagedHSM backend")}
+	}
+	var errs field.ErrorList
+	if m.KeyName == "" {
+		errs = append(errs, field.Required(fldPath.Child("keyName"), ""))
+	}
+	if m.CACertificate == "" {
+		return append(errs, field.Required(fldPath.Child("caCertificate"), ""))
+	}
+	block, _ := pem.Decode([]byte(m.CACertificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return append(errs, field.Invalid(fldPath.Child("caCertificate"), m.CACertificate, "must be a PEM encoded certificate"))
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		errs = append(errs, field.Invalid(fldPath.Child("caCertificate"), m.CACertificate, err.Error()))
+	}
+	return errs
+}
+
+// validate checks the patterns, CIDRs and duration of the policy
+func (p *RequestPolicy) validate(fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, pattern := range p.AllowedDNSNames {
+		for _, label := range strings.Split(pattern, ".") {
+			if _, err := path.Match(label, ""); err != nil {
+				errs = append(errs, field.Invalid(fldPath.Child("allowedDNSNames").Index(i), pattern, err.Error()))
+				break
+			}
+		}
+	}
+	for i, cidr := range p.AllowedIPRanges {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Child("allowedIPRanges").Index(i), cidr, err.Error()))
+		}
+	}
+	if p.MaxDuration != nil && p.MaxDuration.Duration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("maxDuration"), p.MaxDuration.Duration.String(), "must be positive"))
+	}
+	return errs
+}
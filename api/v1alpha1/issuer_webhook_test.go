@@ -0,0 +1,250 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestCACertificate returns a PEM encoded self-signed CA certificate
+func newTestCACertificate(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestIssuerSpecDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		spec IssuerSpec
+		want IssuerSpec
+	}{
+		{
+			name: "backend is left empty",
+			spec: IssuerSpec{CACertificateName: "ca"},
+			want: IssuerSpec{CACertificateName: "ca", CertificateNameTemplate: DefaultCertificateNameTemplate},
+		},
+		{
+			name: "certificate name template is kept",
+			spec: IssuerSpec{Backend: BackendKeyVault, CertificateNameTemplate: "{{ .UID }}"},
+			want: IssuerSpec{Backend: BackendKeyVault, CertificateNameTemplate: "{{ .UID }}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := &Issuer{Spec: *tt.spec.DeepCopy()}
+			issuer.Default()
+			if issuer.Spec != tt.want {
+				t.Errorf("Issuer defaults = %+v, want %+v", issuer.Spec, tt.want)
+			}
+			clusterIssuer := &ClusterIssuer{Spec: *tt.spec.DeepCopy()}
+			clusterIssuer.Default()
+			if clusterIssuer.Spec != tt.want {
+				t.Errorf("ClusterIssuer defaults = %+v, want %+v", clusterIssuer.Spec, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssuerSpecValidate(t *testing.T) {
+	caCertificate := newTestCACertificate(t)
+	tests := []struct {
+		name string
+		spec IssuerSpec
+		// wantFields are the fields reported invalid, none if empty
+		wantFields []string
+	}{
+		{
+			name: "keyvault issuer",
+			spec: IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer"},
+		},
+		{
+			name: "self-signed",
+			spec: IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IsSelfSigned: true},
+		},
+		{
+			name:       "missing issuer name",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", Backend: BackendKeyVault},
+			wantFields: []string{"spec.issuerName"},
+		},
+		{
+			name:       "issuer name and self-signed",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", IsSelfSigned: true},
+			wantFields: []string{"spec.isSelfSigned"},
+		},
+		{
+			name:       "invalid vault name and missing auth Secret",
+			spec:       IssuerSpec{KeyvaultName: "v", IssuerName: "issuer"},
+			wantFields: []string{"spec.keyvaultName", "spec.authSecretName"},
+		},
+		{
+			name: "CA certificate with derived backend",
+			spec: IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", CACertificateName: "ca"},
+		},
+		{
+			name:       "missing CA certificate",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", Backend: BackendKeyVaultCA},
+			wantFields: []string{"spec.caCertificateName"},
+		},
+		{
+			name:       "CA certificate of another backend",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", Backend: BackendKeyVault, IssuerName: "issuer", CACertificateName: "ca"},
+			wantFields: []string{"spec.caCertificateName"},
+		},
+		{
+			name:       "unsupported backend",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", Backend: "Vault"},
+			wantFields: []string{"spec.backend"},
+		},
+		{
+			name: "managed HSM",
+			spec: IssuerSpec{KeyvaultName: "hsm", AuthSecretName: "auth", Backend: BackendManagedHSM, ManagedHSM: &ManagedHSMSpec{KeyName: "key", CACertificate: caCertificate}},
+		},
+		{
+			name:       "missing managed HSM",
+			spec:       IssuerSpec{KeyvaultName: "hsm", AuthSecretName: "auth", Backend: BackendManagedHSM},
+			wantFields: []string{"spec.managedHSM"},
+		},
+		{
+			name:       "missing managed HSM key and certificate",
+			spec:       IssuerSpec{KeyvaultName: "hsm", AuthSecretName: "auth", Backend: BackendManagedHSM, ManagedHSM: &ManagedHSMSpec{}},
+			wantFields: []string{"spec.managedHSM.keyName", "spec.managedHSM.caCertificate"},
+		},
+		{
+			name:       "invalid managed HSM certificate",
+			spec:       IssuerSpec{KeyvaultName: "hsm", AuthSecretName: "auth", Backend: BackendManagedHSM, ManagedHSM: &ManagedHSMSpec{KeyName: "key", CACertificate: "certificate"}},
+			wantFields: []string{"spec.managedHSM.caCertificate"},
+		},
+		{
+			name:       "managed HSM certificate isn't a certificate",
+			spec:       IssuerSpec{KeyvaultName: "hsm", AuthSecretName: "auth", Backend: BackendManagedHSM, ManagedHSM: &ManagedHSMSpec{KeyName: "key", CACertificate: strings.Replace(caCertificate, "CERTIFICATE", "PUBLIC KEY", 2)}},
+			wantFields: []string{"spec.managedHSM.caCertificate"},
+		},
+		{
+			name: "certificate name template",
+			spec: IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", CertificateNameTemplate: "{{ .ClusterID }}-{{ .UID }}"},
+		},
+		{
+			name:       "unparsable certificate name template",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", CertificateNameTemplate: "{{ .Name "},
+			wantFields: []string{"spec.certificateNameTemplate"},
+		},
+		{
+			name:       "unknown field in certificate name template",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", CertificateNameTemplate: "{{ .Certificate }}"},
+			wantFields: []string{"spec.certificateNameTemplate"},
+		},
+		{
+			name: "RSA key properties",
+			spec: IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", KeyProperties: &KeyProperties{KeyType: "RSA-HSM", KeySize: int32Ptr(3072)}},
+		},
+		{
+			name: "EC key properties",
+			spec: IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", KeyProperties: &KeyProperties{KeyType: "EC", Curve: "P-384"}},
+		},
+		{
+			name:       "curve of RSA key",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", KeyProperties: &KeyProperties{KeyType: "RSA", Curve: "P-256"}},
+			wantFields: []string{"spec.keyProperties.curve"},
+		},
+		{
+			name:       "key size of EC key",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", KeyProperties: &KeyProperties{KeyType: "EC-HSM", KeySize: int32Ptr(2048)}},
+			wantFields: []string{"spec.keyProperties.keySize"},
+		},
+		{
+			name:       "key size and curve without key type",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", KeyProperties: &KeyProperties{KeySize: int32Ptr(2048), Curve: "P-256"}},
+			wantFields: []string{"spec.keyProperties.curve"},
+		},
+		{
+			name:       "unsupported key type",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", KeyProperties: &KeyProperties{KeyType: "oct"}},
+			wantFields: []string{"spec.keyProperties.keyType"},
+		},
+		{
+			name:       "min validity greater than max validity",
+			spec:       IssuerSpec{KeyvaultName: "vault", AuthSecretName: "auth", IssuerName: "issuer", MinValidityInMonths: int32Ptr(12), MaxValidityInMonths: int32Ptr(6)},
+			wantFields: []string{"spec.minValidityInMonths"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := &Issuer{ObjectMeta: metav1.ObjectMeta{Name: "issuer"}, Spec: *tt.spec.DeepCopy()}
+			issuer.Default()
+			checkValidationError(t, "Issuer", issuer.ValidateCreate(), tt.wantFields)
+			checkValidationError(t, "Issuer update", issuer.ValidateUpdate(issuer.DeepCopy()), tt.wantFields)
+
+			clusterIssuer := &ClusterIssuer{ObjectMeta: metav1.ObjectMeta{Name: "issuer"}, Spec: *tt.spec.DeepCopy()}
+			clusterIssuer.Default()
+			checkValidationError(t, "ClusterIssuer", clusterIssuer.ValidateCreate(), tt.wantFields)
+			checkValidationError(t, "ClusterIssuer update", clusterIssuer.ValidateUpdate(clusterIssuer.DeepCopy()), tt.wantFields)
+		})
+	}
+}
+
+// checkValidationError checks the error reports exactly the fields
+func checkValidationError(t *testing.T, kind string, err error, wantFields []string) {
+	t.Helper()
+	if len(wantFields) == 0 {
+		if err != nil {
+			t.Errorf("%s validation error = %v", kind, err)
+		}
+		return
+	}
+	if err == nil {
+		t.Fatalf("%s validation succeeded, want errors for %v", kind, wantFields)
+	}
+	for _, field := range wantFields {
+		if !strings.Contains(err.Error(), field+":") {
+			t.Errorf("%s validation error = %v, want an error for %s", kind, err, field)
+		}
+	}
+	if got := strings.Count(err.Error(), "spec."); got != len(wantFields) {
+		t.Errorf("%s validation error = %v, want %d errors", kind, err, len(wantFields))
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
@@ -21,6 +21,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	certmanagerv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -29,7 +31,7 @@ func (in *ClusterIssuer) DeepCopyInto(out *ClusterIssuer) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -88,7 +90,7 @@ func (in *Issuer) DeepCopyInto(out *Issuer) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -164,6 +166,31 @@ func (in *IssuerList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IssuerSpec) DeepCopyInto(out *IssuerSpec) {
 	*out = *in
+	if in.MinValidityInMonths != nil {
+		in, out := &in.MinValidityInMonths, &out.MinValidityInMonths
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxValidityInMonths != nil {
+		in, out := &in.MaxValidityInMonths, &out.MaxValidityInMonths
+		*out = new(int32)
+		**out = **in
+	}
+	if in.KeyProperties != nil {
+		in, out := &in.KeyProperties, &out.KeyProperties
+		*out = new(KeyProperties)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ManagedHSM != nil {
+		in, out := &in.ManagedHSM, &out.ManagedHSM
+		*out = new(ManagedHSMSpec)
+		**out = **in
+	}
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		*out = new(RequestPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerSpec.
@@ -197,3 +224,88 @@ func (in *IssuerStatus) DeepCopy() *IssuerStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyProperties) DeepCopyInto(out *KeyProperties) {
+	*out = *in
+	if in.KeySize != nil {
+		in, out := &in.KeySize, &out.KeySize
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Exportable != nil {
+		in, out := &in.Exportable, &out.Exportable
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ReuseKey != nil {
+		in, out := &in.ReuseKey, &out.ReuseKey
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyProperties.
+func (in *KeyProperties) DeepCopy() *KeyProperties {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyProperties)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedHSMSpec) DeepCopyInto(out *ManagedHSMSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedHSMSpec.
+func (in *ManagedHSMSpec) DeepCopy() *ManagedHSMSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedHSMSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RequestPolicy) DeepCopyInto(out *RequestPolicy) {
+	*out = *in
+	if in.AllowedDNSNames != nil {
+		in, out := &in.AllowedDNSNames, &out.AllowedDNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedIPRanges != nil {
+		in, out := &in.AllowedIPRanges, &out.AllowedIPRanges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedUsages != nil {
+		in, out := &in.AllowedUsages, &out.AllowedUsages
+		*out = make([]certmanagerv1.KeyUsage, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxDuration != nil {
+		in, out := &in.MaxDuration, &out.MaxDuration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RequiredSubjectFields != nil {
+		in, out := &in.RequiredSubjectFields, &out.RequiredSubjectFields
+		*out = make([]SubjectField, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RequestPolicy.
+func (in *RequestPolicy) DeepCopy() *RequestPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RequestPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
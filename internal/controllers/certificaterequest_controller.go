@@ -20,6 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
 	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
@@ -30,12 +31,41 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/clock"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	azureissuerv1alpha1 "github.com/aramase/azure-external-issuer/api/v1alpha1"
+	"github.com/aramase/azure-external-issuer/internal/issuer/requestpolicy"
 	"github.com/aramase/azure-external-issuer/internal/issuer/signer"
 	issuerutil "github.com/aramase/azure-external-issuer/internal/issuer/util"
+	"github.com/aramase/azure-external-issuer/internal/metrics"
+)
+
+const (
+	// certificateOperationAnnotation records the request ID of the keyvault
+	// certificate operation issuing the certificate for a CertificateRequest
+	certificateOperationAnnotation = "azure-issuer.microsoft.com/certificate-operation"
+	// certificateNameAnnotation records the name of the keyvault certificate
+	// issued for a CertificateRequest, so the name doesn't change while the
+	// certificate is being issued
+	certificateNameAnnotation = "azure-issuer.microsoft.com/certificate-name"
+
+	// operationPollInterval is how often pending keyvault certificate operations are checked
+	operationPollInterval = 15 * time.Second
+	// throttledRetryInterval is how long to wait before retrying a request
+	// throttled by keyvault when keyvault doesn't request a delay
+	throttledRetryInterval = time.Minute
+
+	// issuerRefField indexes CertificateRequests by the kind and name of the
+	// issuer of this group they reference
+	issuerRefField = "spec.issuerRef"
 )
 
 var (
@@ -53,11 +83,22 @@ type CertificateRequestReconciler struct {
 	Clock                    clock.Clock
 	ClusterResourceNamespace string
 	CheckApprovedCondition   bool
+	// ClusterID identifies the cluster in keyvault certificate names, so that
+	// clusters sharing a vault don't overwrite each other's certificates
+	ClusterID string
+	// SignerBuilder builds the Signer that issues the certificates of an
+	// issuer, signer.NewSigner for the registered backends
+	SignerBuilder signer.SignerBuilder
+	// SignerCache is shared with the IssuerReconcilers to reuse keyvault
+	// clients and tokens. A new signer is built on every reconcile if it is nil.
+	SignerCache *signer.Cache
+	Recorder    record.EventRecorder
 }
 
-// +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch;patch
 // +kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
 	log := ctrl.LoggerFrom(ctx)
@@ -69,6 +110,7 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 			return ctrl.Result{}, fmt.Errorf("unexpected get error: %v", err)
 		}
 		log.Info("CertificateRequest not found. Ignoring")
+		metrics.SetPending(req.NamespacedName, false)
 		return ctrl.Result{}, nil
 	}
 
@@ -87,6 +129,13 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, nil
 	}
 
+	// Ignore CertificateRequest if it has failed, failures are permanent
+	if ready := cmutil.GetCertificateRequestCondition(&certificateRequest, cmapi.CertificateRequestConditionReady); ready != nil &&
+		ready.Reason == cmapi.CertificateRequestReasonFailed && certificateRequest.Status.FailureTime != nil {
+		log.Info("CertificateRequest has failed. Ignoring.")
+		return ctrl.Result{}, nil
+	}
+
 	// We now have a CertificateRequest that belongs to us so we are responsible
 	// for updating its Ready condition.
 	setReadyCondition := func(status cmmeta.ConditionStatus, reason, message string) {
@@ -115,12 +164,13 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 	if cmutil.CertificateRequestIsDenied(&certificateRequest) {
 		log.Info("CertificateRequest has been denied yet. Marking as failed.")
 
+		message := "The CertificateRequest was denied by an approval controller"
 		if certificateRequest.Status.FailureTime == nil {
 			nowTime := metav1.NewTime(r.Clock.Now())
 			certificateRequest.Status.FailureTime = &nowTime
+			r.Recorder.Event(&certificateRequest, corev1.EventTypeWarning, reasonDenied, message)
 		}
 
-		message := "The CertificateRequest was denied by an approval controller"
 		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonDenied, message)
 		return ctrl.Result{}, nil
 	}
@@ -186,6 +236,30 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return ctrl.Result{}, errIssuerNotReady
 	}
 
+	// Failed CertificateRequests are not retried, so record when they failed
+	setFailed := func(message string) {
+		if certificateRequest.Status.FailureTime == nil {
+			nowTime := metav1.NewTime(r.Clock.Now())
+			certificateRequest.Status.FailureTime = &nowTime
+			r.Recorder.Event(&certificateRequest, corev1.EventTypeWarning, reasonFailed, message)
+		}
+		metrics.SetPending(req.NamespacedName, false)
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, message)
+	}
+
+	// The policy only depends on the CertificateRequest and the issuer, so
+	// violations are failed before the credentials are read or keyvault is
+	// called. The maximum duration is checked against the validity the
+	// backend issues, which keyvault rounds up to calendar months.
+	issuedDuration := func(duration time.Duration) time.Duration {
+		return signer.IssuedDuration(*issuerSpec, duration)
+	}
+	if err := requestpolicy.Check(issuerSpec.Policy, &certificateRequest, issuedDuration); err != nil {
+		log.Error(err, "CertificateRequest violates the issuer policy. Marking as failed.")
+		setFailed(err.Error())
+		return ctrl.Result{}, nil
+	}
+
 	secretName := types.NamespacedName{
 		Name:      issuerSpec.AuthSecretName,
 		Namespace: secretNamespace,
@@ -193,26 +267,230 @@ func (r *CertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.R
 
 	var secret corev1.Secret
 	if err := r.Get(ctx, secretName, &secret); err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w, secret name: %s, reason: %v", errGetAuthSecret, secretName, err)
+		err = fmt.Errorf("%w, secret name: %s, reason: %v", errGetAuthSecret, secretName, err)
+		r.Recorder.Event(&certificateRequest, corev1.EventTypeWarning, reasonCredentialsError, err.Error())
+		return ctrl.Result{}, err
 	}
 
-	issuerClient, err := signer.NewSigner(secret.Data, issuerSpec.KeyvaultName)
+	issuerClient, err := r.getSigner(issuer, &secret, issuerSpec)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to get issuer client for %s: %v", issuerSpec.IssuerName, err)
+		err = fmt.Errorf("failed to get issuer client for %s: %v", issuerSpec.IssuerName, err)
+		r.Recorder.Event(&certificateRequest, corev1.EventTypeWarning, reasonCredentialsError, err.Error())
+		return ctrl.Result{}, err
 	}
 
-	signed, err := issuerClient.Sign(ctx, certificateRequest.Spec.Request, certificateRequest.Name, *issuerSpec)
+	certificateName, err := r.certificateName(&certificateRequest, issuerSpec)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w: %v", errSignerSign, err)
+		log.Error(err, "Unable to name the keyvault certificate. Marking as failed.")
+		setFailed(err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	signRequest := signer.Request{
+		Name:        certificateName,
+		CSR:         certificateRequest.Spec.Request,
+		Usages:      certificateRequest.Spec.Usages,
+		IsCA:        certificateRequest.Spec.IsCA,
+		Duration:    requestedDuration(&certificateRequest),
+		OperationID: certificateRequest.Annotations[certificateOperationAnnotation],
+	}
+
+	signed, err := issuerClient.Sign(ctx, signRequest, *issuerSpec)
+	// keyvault issues the certificate asynchronously, record the operation
+	// and poll it in subsequent reconciles
+	var pending *signer.PendingError
+	if errors.As(err, &pending) {
+		if pending.OperationID != signRequest.OperationID {
+			r.Recorder.Eventf(&certificateRequest, corev1.EventTypeNormal, reasonCertificateCreated,
+				"Created certificate %s in keyvault %s, operation %s", certificateName, issuerSpec.KeyvaultName, pending.OperationID)
+		}
+		if err := r.setOperation(ctx, &certificateRequest, certificateName, pending.OperationID); err != nil {
+			return ctrl.Result{}, err
+		}
+		metrics.SetPending(req.NamespacedName, true)
+		log.Info("Keyvault certificate operation in progress.", "operation", pending.OperationID)
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, pending.Error())
+		return ctrl.Result{RequeueAfter: operationPollInterval}, nil
+	}
+	switch {
+	case err == nil:
+	case signer.IsPermanent(err):
+		// retrying can't succeed, cert-manager creates a new CertificateRequest
+		// when the Certificate is retried
+		log.Error(err, "Unable to sign certificate. Marking as failed.")
+		setFailed(err.Error())
+		return ctrl.Result{}, nil
+	case errors.Is(err, signer.ErrThrottled):
+		// wait for the delay requested by keyvault rather than backing off
+		retryAfter := throttledRetryAfter(err)
+		log.Info("Keyvault throttled the request. Retrying later.", "reason", err.Error(), "retryAfter", retryAfter)
+		setReadyCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, err.Error())
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	default:
+		err = fmt.Errorf("%w: %v", errSignerSign, err)
+		r.Recorder.Event(&certificateRequest, corev1.EventTypeWarning, reasonSignError, err.Error())
+		return ctrl.Result{}, err
 	}
-	certificateRequest.Status.Certificate = signed
+	certificateRequest.Status.Certificate = signed.Certificate
+	certificateRequest.Status.CA = signed.CA
+
+	metrics.SetPending(req.NamespacedName, false)
+	metrics.ObserveIssuance(r.Clock.Now().Sub(certificateRequest.CreationTimestamp.Time))
+	r.Recorder.Eventf(&certificateRequest, corev1.EventTypeNormal, reasonIssued, "Certificate %s issued by keyvault %s", certificateName, issuerSpec.KeyvaultName)
 
 	setReadyCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed")
 	return ctrl.Result{}, nil
 }
 
+// throttledRetryAfter returns the delay keyvault requested for a throttled
+// request, throttledRetryInterval if it didn't request one
+func throttledRetryAfter(err error) time.Duration {
+	var kvErr *signer.Error
+	if errors.As(err, &kvErr) && kvErr.RetryAfter > 0 {
+		return kvErr.RetryAfter
+	}
+	return throttledRetryInterval
+}
+
+// requestedDuration returns the duration requested in the CertificateRequest, zero if not set
+func requestedDuration(certificateRequest *cmapi.CertificateRequest) time.Duration {
+	if certificateRequest.Spec.Duration == nil {
+		return 0
+	}
+	return certificateRequest.Spec.Duration.Duration
+}
+
+// getSigner returns the Signer of the issuer, reusing the cached one while the
+// auth Secret, the vault and the backend of the issuer are unchanged
+func (r *CertificateRequestReconciler) getSigner(issuer client.Object, secret *corev1.Secret, issuerSpec *azureissuerv1alpha1.IssuerSpec) (signer.Signer, error) {
+	build := func() (signer.Signer, error) {
+		return r.SignerBuilder(secret.Data, *issuerSpec)
+	}
+	if r.SignerCache == nil {
+		return build()
+	}
+	return r.SignerCache.GetSigner(signerCacheKey(issuer, secret, issuerSpec), build)
+}
+
+// certificateName returns the name of the keyvault certificate for the
+// CertificateRequest. The name recorded when the certificate operation started
+// is kept, so changes to the issuer don't orphan the pending operation.
+func (r *CertificateRequestReconciler) certificateName(certificateRequest *cmapi.CertificateRequest, issuerSpec *azureissuerv1alpha1.IssuerSpec) (string, error) {
+	if name := certificateRequest.Annotations[certificateNameAnnotation]; name != "" && certificateRequest.Annotations[certificateOperationAnnotation] != "" {
+		return name, nil
+	}
+	return signer.CertificateName(issuerSpec.CertificateNameTemplate, signer.NameParameters{
+		Namespace: certificateRequest.Namespace,
+		Name:      certificateRequest.Name,
+		UID:       string(certificateRequest.UID),
+		ClusterID: r.ClusterID,
+	})
+}
+
+// setOperation records the keyvault certificate and operation for the CertificateRequest
+func (r *CertificateRequestReconciler) setOperation(ctx context.Context, certificateRequest *cmapi.CertificateRequest, certificateName, operationID string) error {
+	if certificateRequest.Annotations[certificateOperationAnnotation] == operationID &&
+		certificateRequest.Annotations[certificateNameAnnotation] == certificateName {
+		return nil
+	}
+	patch := client.MergeFrom(certificateRequest.DeepCopy())
+	if certificateRequest.Annotations == nil {
+		certificateRequest.Annotations = map[string]string{}
+	}
+	certificateRequest.Annotations[certificateOperationAnnotation] = operationID
+	certificateRequest.Annotations[certificateNameAnnotation] = certificateName
+	if err := r.Patch(ctx, certificateRequest, patch); err != nil {
+		return fmt.Errorf("failed to record certificate operation %s: %v", operationID, err)
+	}
+	return nil
+}
+
 func (r *CertificateRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.SignerBuilder == nil {
+		return errors.New("SignerBuilder is required")
+	}
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &cmapi.CertificateRequest{}, issuerRefField, func(obj client.Object) []string {
+		issuerRef := obj.(*cmapi.CertificateRequest).Spec.IssuerRef
+		if issuerRef.Group != azureissuerv1alpha1.GroupVersion.Group {
+			return nil
+		}
+		return []string{issuerRefKey(issuerRef.Kind, issuerRef.Name)}
+	})
+	if err != nil {
+		return err
+	}
+	// resume issuance as soon as an issuer becomes ready again
+	issuerReady := builder.WithPredicates(predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !issuerIsReady(e.ObjectOld) && issuerIsReady(e.ObjectNew)
+		},
+	})
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cmapi.CertificateRequest{}).
+		Watches(&source.Kind{Type: &azureissuerv1alpha1.Issuer{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForIssuer), issuerReady).
+		Watches(&source.Kind{Type: &azureissuerv1alpha1.ClusterIssuer{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForIssuer), issuerReady).
 		Complete(r)
 }
+
+// requestsForIssuer returns the pending CertificateRequests of the issuer
+func (r *CertificateRequestReconciler) requestsForIssuer(obj client.Object) []reconcile.Request {
+	log := ctrl.Log.WithName("controllers").WithName("CertificateRequest")
+
+	var kind string
+	opts := []client.ListOption{}
+	switch obj.(type) {
+	case *azureissuerv1alpha1.Issuer:
+		kind = "Issuer"
+		opts = append(opts, client.InNamespace(obj.GetNamespace()))
+	case *azureissuerv1alpha1.ClusterIssuer:
+		kind = "ClusterIssuer"
+	default:
+		return nil
+	}
+	opts = append(opts, client.MatchingFields{issuerRefField: issuerRefKey(kind, obj.GetName())})
+
+	var certificateRequests cmapi.CertificateRequestList
+	if err := r.List(context.Background(), &certificateRequests, opts...); err != nil {
+		log.Error(err, "Unable to list CertificateRequests for issuer", "kind", kind, "issuer", client.ObjectKeyFromObject(obj))
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range certificateRequests.Items {
+		certificateRequest := &certificateRequests.Items[i]
+		if !isPending(certificateRequest) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(certificateRequest)})
+	}
+	return requests
+}
+
+// issuerRefKey is the index value of CertificateRequests referencing the issuer
+func issuerRefKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// issuerIsReady returns true if the Issuer or ClusterIssuer has a Ready condition
+func issuerIsReady(obj client.Object) bool {
+	_, issuerStatus, err := issuerutil.GetSpecAndStatus(obj)
+	if err != nil {
+		return false
+	}
+	return issuerutil.IsReady(issuerStatus)
+}
+
+// isPending returns true if the CertificateRequest is neither issued, failed nor denied
+func isPending(certificateRequest *cmapi.CertificateRequest) bool {
+	ready := cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)
+	if ready == nil {
+		return true
+	}
+	switch ready.Reason {
+	case cmapi.CertificateRequestReasonIssued, cmapi.CertificateRequestReasonFailed, cmapi.CertificateRequestReasonDenied:
+		return false
+	}
+	return ready.Status != cmmeta.ConditionTrue
+}
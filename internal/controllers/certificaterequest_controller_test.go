@@ -0,0 +1,518 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"time"
+
+	cmutil "github.com/jetstack/cert-manager/pkg/api/util"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	azureissuerv1alpha1 "github.com/aramase/azure-external-issuer/api/v1alpha1"
+	issuerutil "github.com/aramase/azure-external-issuer/internal/issuer/util"
+	"github.com/aramase/azure-external-issuer/internal/testutil/fakekeyvault"
+)
+
+// certificateRequestReadyCondition returns the Ready condition of the
+// CertificateRequest, nil if it has none
+func certificateRequestReadyCondition(certificateRequest *cmapi.CertificateRequest) func() *cmapi.CertificateRequestCondition {
+	return func() *cmapi.CertificateRequestCondition {
+		if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(certificateRequest), certificateRequest); err != nil {
+			return nil
+		}
+		return cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)
+	}
+}
+
+// beCertificateRequestCondition matches a CertificateRequest condition with the
+// status, reason and a message containing the substring
+func beCertificateRequestCondition(status cmmeta.ConditionStatus, reason, message string) types.GomegaMatcher {
+	return And(
+		Not(BeNil()),
+		WithTransform(func(c *cmapi.CertificateRequestCondition) cmmeta.ConditionStatus { return c.Status }, Equal(status)),
+		WithTransform(func(c *cmapi.CertificateRequestCondition) string { return c.Reason }, Equal(reason)),
+		WithTransform(func(c *cmapi.CertificateRequestCondition) string { return c.Message }, ContainSubstring(message)),
+	)
+}
+
+// testDNSName is the DNS name of the CSR of test CertificateRequests
+const testDNSName = "www.example.com"
+
+// newTestCSR returns a PEM encoded CSR for testDNSName
+func newTestCSR() []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: testDNSName},
+		DNSNames: []string{testDNSName},
+	}, key)
+	Expect(err).ToNot(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+}
+
+// createCertificateRequest creates a CertificateRequest for the issuer of this group
+func createCertificateRequest(namespace, name, kind, issuerName string) *cmapi.CertificateRequest {
+	certificateRequest := newCertificateRequest(namespace, name, kind, issuerName)
+	Expect(k8sClient.Create(context.Background(), certificateRequest)).To(Succeed())
+	return certificateRequest
+}
+
+// newCertificateRequest returns a CertificateRequest for the issuer of this group
+func newCertificateRequest(namespace, name, kind, issuerName string) *cmapi.CertificateRequest {
+	return &cmapi.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: cmapi.CertificateRequestSpec{
+			Request: newTestCSR(),
+			IssuerRef: cmmeta.ObjectReference{
+				Group: azureissuerv1alpha1.GroupVersion.Group,
+				Kind:  kind,
+				Name:  issuerName,
+			},
+		},
+	}
+}
+
+// setCertificateRequestCondition sets a condition of the CertificateRequest the
+// way an approval controller does, retrying on conflicts with the controller
+func setCertificateRequestCondition(certificateRequest *cmapi.CertificateRequest, conditionType cmapi.CertificateRequestConditionType, reason string) {
+	Eventually(func() error {
+		if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(certificateRequest), certificateRequest); err != nil {
+			return err
+		}
+		cmutil.SetCertificateRequestCondition(certificateRequest, conditionType, cmmeta.ConditionTrue, reason, "set by test")
+		return k8sClient.Status().Update(context.Background(), certificateRequest)
+	}, timeout, interval).Should(Succeed())
+}
+
+func approve(certificateRequest *cmapi.CertificateRequest) {
+	setCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionApproved, "Approved")
+}
+
+var _ = Describe("CertificateRequestReconciler", func() {
+	var namespace string
+
+	BeforeEach(func() {
+		namespace = createNamespace()
+	})
+
+	It("ignores CertificateRequests that have not been approved", func() {
+		createReadyIssuer(namespace, "issuer", "issuer")
+		certificateRequest := createCertificateRequest(namespace, "unapproved", "Issuer", "issuer")
+
+		Consistently(certificateRequestReadyCondition(certificateRequest), "2s", interval).Should(BeNil())
+	})
+
+	It("signs approved CertificateRequests", func() {
+		createReadyIssuer(namespace, "issuer", "issuer")
+		certificateRequest := createCertificateRequest(namespace, "approved", "Issuer", "issuer")
+		approve(certificateRequest)
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed"))
+		Expect(certificateRequest.Status.Certificate).To(Equal(testCertificate))
+		Expect(certificateRequest.Status.CA).To(Equal(testCA))
+	})
+
+	It("marks denied CertificateRequests as denied", func() {
+		createReadyIssuer(namespace, "issuer", "issuer")
+		certificateRequest := createCertificateRequest(namespace, "denied", "Issuer", "issuer")
+		setCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionDenied, "Denied")
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonDenied, "denied"))
+		Expect(certificateRequest.Status.FailureTime).NotTo(BeNil())
+		Expect(certificateRequest.Status.Certificate).To(BeEmpty())
+	})
+
+	It("fails CertificateRequests for unknown issuer kinds", func() {
+		certificateRequest := createCertificateRequest(namespace, "unknown-kind", "UnknownIssuer", "issuer")
+		approve(certificateRequest)
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, errIssuerRef.Error()))
+	})
+
+	It("waits for the issuer to be ready", func() {
+		createAuthSecret(namespace, "not-ready-auth")
+		issuer := &azureissuerv1alpha1.Issuer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "not-ready"},
+			Spec:       newIssuerSpec(checkFailsIssuerName, "not-ready-auth"),
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(
+			beIssuerCondition(azureissuerv1alpha1.ConditionFalse, "mock issuer check failed"))
+
+		certificateRequest := createCertificateRequest(namespace, "issuer-not-ready", "Issuer", "not-ready")
+		approve(certificateRequest)
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, errIssuerNotReady.Error()))
+		Expect(certificateRequest.Status.FailureTime).To(BeNil())
+	})
+
+	It("signs pending CertificateRequests once their issuer becomes ready", func() {
+		createAuthSecret(namespace, "flip-auth")
+		setClientSecret(namespace, "flip-auth", invalidClientSecret)
+		issuer := &azureissuerv1alpha1.Issuer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "flip"},
+			Spec:       newIssuerSpec("issuer", "flip-auth"),
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(
+			beIssuerCondition(azureissuerv1alpha1.ConditionFalse, "mock credentials rejected"))
+
+		pending := createCertificateRequest(namespace, "pending", "Issuer", "flip")
+		approve(pending)
+		denied := createCertificateRequest(namespace, "denied", "Issuer", "flip")
+		setCertificateRequestCondition(denied, cmapi.CertificateRequestConditionDenied, "Denied")
+		createCertificateRequest(namespace, "other-issuer", "Issuer", "other")
+		Eventually(certificateRequestReadyCondition(pending), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, errIssuerNotReady.Error()))
+		Eventually(certificateRequestReadyCondition(denied), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonDenied, "denied"))
+
+		// only the pending CertificateRequests of the issuer are reconciled
+		// when it becomes ready
+		Eventually(func() []reconcile.Request {
+			return certificateRequestReconciler.requestsForIssuer(issuer)
+		}, timeout, interval).Should(ConsistOf(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pending)}))
+
+		setClientSecret(namespace, "flip-auth", "client-secret")
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(
+			beIssuerCondition(azureissuerv1alpha1.ConditionTrue, "Success"))
+		Eventually(certificateRequestReadyCondition(pending), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed"))
+		Expect(pending.Status.Certificate).To(Equal(testCertificate))
+		Eventually(func() []reconcile.Request {
+			return certificateRequestReconciler.requestsForIssuer(issuer)
+		}, timeout, interval).Should(BeEmpty())
+	})
+
+	It("fails CertificateRequests the signer refuses permanently", func() {
+		createReadyIssuer(namespace, "issuer", signFailsIssuerName)
+		certificateRequest := createCertificateRequest(namespace, "sign-fails", "Issuer", "issuer")
+		approve(certificateRequest)
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, "mock signing refused"))
+		Expect(certificateRequest.Status.FailureTime).NotTo(BeNil())
+	})
+
+	It("requeues throttled CertificateRequests after the delay requested by keyvault", func() {
+		createReadyIssuer(namespace, "issuer", throttledIssuerName)
+		certificateRequest := createCertificateRequest(namespace, "throttled", "Issuer", "issuer")
+		approve(certificateRequest)
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, "mock signing throttled"))
+		throttled := time.Now()
+
+		// the status update of the throttled CertificateRequest triggers a
+		// reconcile, it must not be signed before the delay has passed
+		Consistently(certificateRequestReadyCondition(certificateRequest), mockThrottleDelay/2, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, "mock signing throttled"))
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed"))
+		Expect(time.Since(throttled)).To(BeNumerically(">=", mockThrottleDelay-time.Second))
+		Expect(certificateRequest.Status.FailureTime).To(BeNil())
+	})
+
+	It("enforces the policy of the issuer", func() {
+		createAuthSecret(namespace, "policy-auth")
+		spec := newIssuerSpec("issuer", "policy-auth")
+		spec.Policy = &azureissuerv1alpha1.RequestPolicy{
+			AllowedDNSNames: []string{"*.example.org"},
+		}
+		issuer := &azureissuerv1alpha1.Issuer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "policy"},
+			Spec:       spec,
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(
+			beIssuerCondition(azureissuerv1alpha1.ConditionTrue, "Success"))
+
+		certificateRequest := createCertificateRequest(namespace, "policy-violation", "Issuer", "policy")
+		approve(certificateRequest)
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, `DNS name "`+testDNSName+`" is not allowed`))
+		Expect(certificateRequest.Status.FailureTime).NotTo(BeNil())
+		Expect(certificateRequest.Status.Certificate).To(BeEmpty())
+
+		Eventually(func() error {
+			if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(issuer), issuer); err != nil {
+				return err
+			}
+			issuer.Spec.Policy.AllowedDNSNames = []string{"*.example.com"}
+			return k8sClient.Update(context.Background(), issuer)
+		}, timeout, interval).Should(Succeed())
+
+		certificateRequest = createCertificateRequest(namespace, "policy-allowed", "Issuer", "policy")
+		approve(certificateRequest)
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed"))
+	})
+
+	It("signs CertificateRequests of a ClusterIssuer with the Secret in the cluster resource namespace", func() {
+		createAuthSecret(testClusterResourceNamespace, namespace+"-auth")
+		issuer := &azureissuerv1alpha1.ClusterIssuer{
+			ObjectMeta: metav1.ObjectMeta{Name: namespace + "-cluster"},
+			Spec:       newIssuerSpec("issuer", namespace+"-auth"),
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+		defer func() {
+			Expect(k8sClient.Delete(context.Background(), issuer)).To(Succeed())
+		}()
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(
+			beIssuerCondition(azureissuerv1alpha1.ConditionTrue, "Success"))
+
+		certificateRequest := createCertificateRequest(namespace, "cluster-issuer", "ClusterIssuer", issuer.Name)
+		approve(certificateRequest)
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed"))
+		Expect(certificateRequest.Status.Certificate).To(Equal(testCertificate))
+	})
+
+	It("issues certificates with a keyvault certificate issuer", func() {
+		vault, caCert := createKeyvaultIssuer(namespace, "keyvault")
+		vault.SetIssueDelay(2 * time.Second)
+		certificateRequest := createCertificateRequest(namespace, "keyvault", "Issuer", "keyvault")
+		approve(certificateRequest)
+
+		// keyvault issues the certificate asynchronously, the operation is
+		// recorded and polled until it completes
+		Eventually(certificateRequestReadyCondition(certificateRequest), timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, "is in progress"))
+		Expect(certificateRequest.Annotations).To(HaveKey(certificateOperationAnnotation))
+		certificateName := certificateRequest.Annotations[certificateNameAnnotation]
+		Expect(certificateName).NotTo(BeEmpty())
+
+		Eventually(certificateRequestReadyCondition(certificateRequest), operationPollInterval+timeout, interval).Should(
+			beCertificateRequestCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed"))
+		block, _ := pem.Decode(certificateRequest.Status.Certificate)
+		Expect(block).NotTo(BeNil())
+		cert, err := x509.ParseCertificate(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cert.DNSNames).To(ConsistOf(testDNSName))
+		Expect(cert.CheckSignatureFrom(caCert)).To(Succeed())
+		Expect(vault.Certificate(certificateName).Raw).To(Equal(cert.Raw))
+		Expect(certificateRequest.Status.CA).To(Equal(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})))
+	})
+})
+
+// createKeyvaultIssuer adds a vault with a certificate issuer to the fake
+// keyvault and creates a ready Issuer signing with it
+func createKeyvaultIssuer(namespace, name string) (*fakekeyvault.Vault, *x509.Certificate) {
+	// the vault name is unique, keyvault limits the request rate per vault
+	vaultName := namespace + "-" + name
+	vault := kvServer.AddVault(vaultName)
+	caCert := vault.AddIssuer("test-ca")
+	createAuthSecret(namespace, name+"-auth")
+	spec := newIssuerSpec("test-ca", name+"-auth")
+	spec.KeyvaultName = vaultName
+	issuer := &azureissuerv1alpha1.Issuer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       spec,
+	}
+	Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+	Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionTrue, "Success"))
+	return vault, caCert
+}
+
+// newFakeReconciler returns a CertificateRequestReconciler with a fake client
+// holding the objects, for tests calling Reconcile directly. Events are recorded
+// by the returned FakeRecorder.
+func newFakeReconciler(fakeClock clock.Clock, objects ...client.Object) (*CertificateRequestReconciler, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	return &CertificateRequestReconciler{
+		Client:                   fake.NewClientBuilder().WithScheme(testScheme).WithObjects(objects...).Build(),
+		Scheme:                   testScheme,
+		ClusterResourceNamespace: testClusterResourceNamespace,
+		Clock:                    fakeClock,
+		CheckApprovedCondition:   true,
+		SignerBuilder:            newMockSigner,
+		Recorder:                 recorder,
+	}, recorder
+}
+
+// newReadyIssuer returns a ready Issuer and its auth Secret
+func newReadyIssuer(namespace, name, issuerName string) (*azureissuerv1alpha1.Issuer, *corev1.Secret) {
+	issuer := &azureissuerv1alpha1.Issuer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       newIssuerSpec(issuerName, name+"-auth"),
+	}
+	issuerutil.SetReadyCondition(&issuer.Status, azureissuerv1alpha1.ConditionTrue, "Checked", "Success")
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name + "-auth"},
+		Data:       map[string][]byte{"aadClientID": []byte("client-id"), "aadClientSecret": []byte("client-secret")},
+	}
+	return issuer, secret
+}
+
+// newApprovedCertificateRequest returns an approved CertificateRequest for the
+// Issuer with an initialized Ready condition
+func newApprovedCertificateRequest(namespace, name, issuerName string) *cmapi.CertificateRequest {
+	certificateRequest := newCertificateRequest(namespace, name, "Issuer", issuerName)
+	cmutil.SetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionApproved, cmmeta.ConditionTrue, "Approved", "set by test")
+	cmutil.SetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady, cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, "Initializing")
+	return certificateRequest
+}
+
+// reconcileCertificateRequest reconciles the CertificateRequest and reads it back
+func reconcileCertificateRequest(r *CertificateRequestReconciler, certificateRequest *cmapi.CertificateRequest) ctrl.Result {
+	key := client.ObjectKeyFromObject(certificateRequest)
+	result, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: key})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(r.Get(context.Background(), key, certificateRequest)).To(Succeed())
+	return result
+}
+
+// expectEvent expects the next Event recorded by the recorder to be of the type
+// and reason, with a message containing the substring
+func expectEvent(recorder *record.FakeRecorder, eventType, reason, message string) {
+	Expect(recorder.Events).To(Receive(And(
+		HavePrefix(eventType+" "+reason+" "),
+		ContainSubstring(message),
+	)))
+}
+
+var _ = Describe("CertificateRequestReconciler.Reconcile", func() {
+	const namespace = "reconcile"
+	var fakeClock *clock.FakeClock
+
+	BeforeEach(func() {
+		fakeClock = clock.NewFakeClock(time.Now())
+	})
+
+	It("records the keyvault operation of pending CertificateRequests and polls it", func() {
+		issuer, secret := newReadyIssuer(namespace, "issuer", pendingIssuerName)
+		certificateRequest := newApprovedCertificateRequest(namespace, "pending", "issuer")
+		r, recorder := newFakeReconciler(fakeClock, issuer, secret, certificateRequest)
+
+		result := reconcileCertificateRequest(r, certificateRequest)
+		Expect(result).To(Equal(ctrl.Result{RequeueAfter: operationPollInterval}))
+		certificateName := certificateRequest.Annotations[certificateNameAnnotation]
+		Expect(certificateName).NotTo(BeEmpty())
+		Expect(certificateRequest.Annotations).To(HaveKeyWithValue(certificateOperationAnnotation, mockOperationID(certificateName)))
+		Expect(cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)).To(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonPending, "mock operation in progress"))
+		expectEvent(recorder, corev1.EventTypeNormal, reasonCertificateCreated, mockOperationID(certificateName))
+
+		// the operation is polled with the recorded certificate name, even
+		// though the issuer now names certificates differently
+		Expect(r.Get(context.Background(), client.ObjectKeyFromObject(issuer), issuer)).To(Succeed())
+		issuer.Spec.CertificateNameTemplate = "renamed-{{ .Name }}"
+		Expect(r.Update(context.Background(), issuer)).To(Succeed())
+		result = reconcileCertificateRequest(r, certificateRequest)
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)).To(
+			beCertificateRequestCondition(cmmeta.ConditionTrue, cmapi.CertificateRequestReasonIssued, "Signed"))
+		Expect(certificateRequest.Annotations).To(HaveKeyWithValue(certificateNameAnnotation, certificateName))
+		Expect(certificateRequest.Status.Certificate).To(Equal(testCertificate))
+		Expect(certificateRequest.Status.FailureTime).To(BeNil())
+		// polling the operation doesn't record it as created again
+		expectEvent(recorder, corev1.EventTypeNormal, reasonIssued, certificateName)
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("fails CertificateRequests whose keyvault operation failed", func() {
+		issuer, secret := newReadyIssuer(namespace, "issuer", pendingFailsIssuerName)
+		certificateRequest := newApprovedCertificateRequest(namespace, "pending-fails", "issuer")
+		r, recorder := newFakeReconciler(fakeClock, issuer, secret, certificateRequest)
+
+		result := reconcileCertificateRequest(r, certificateRequest)
+		Expect(result).To(Equal(ctrl.Result{RequeueAfter: operationPollInterval}))
+		Expect(certificateRequest.Status.FailureTime).To(BeNil())
+		expectEvent(recorder, corev1.EventTypeNormal, reasonCertificateCreated, "operation")
+
+		result = reconcileCertificateRequest(r, certificateRequest)
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)).To(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonFailed, "is cancelled"))
+		Expect(certificateRequest.Status.FailureTime).NotTo(BeNil())
+		Expect(certificateRequest.Status.FailureTime.Time).To(BeTemporally("~", fakeClock.Now(), time.Second))
+		Expect(certificateRequest.Status.Certificate).To(BeEmpty())
+		expectEvent(recorder, corev1.EventTypeWarning, reasonFailed, "is cancelled")
+
+		// failures are permanent, the operation isn't polled again
+		failureTime := certificateRequest.Status.FailureTime
+		fakeClock.Step(time.Hour)
+		result = reconcileCertificateRequest(r, certificateRequest)
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(certificateRequest.Status.FailureTime.Equal(failureTime)).To(BeTrue())
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("records an Event when a CertificateRequest is denied", func() {
+		issuer, secret := newReadyIssuer(namespace, "issuer", "issuer")
+		certificateRequest := newCertificateRequest(namespace, "denied", "Issuer", "issuer")
+		cmutil.SetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionDenied, cmmeta.ConditionTrue, "Denied", "set by test")
+		r, recorder := newFakeReconciler(fakeClock, issuer, secret, certificateRequest)
+
+		Expect(reconcileCertificateRequest(r, certificateRequest)).To(Equal(ctrl.Result{}))
+		Expect(cmutil.GetCertificateRequestCondition(certificateRequest, cmapi.CertificateRequestConditionReady)).To(
+			beCertificateRequestCondition(cmmeta.ConditionFalse, cmapi.CertificateRequestReasonDenied, "denied"))
+		expectEvent(recorder, corev1.EventTypeWarning, reasonDenied, "denied by an approval controller")
+
+		// the denial is only recorded once
+		reconcileCertificateRequest(r, certificateRequest)
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("records an Event when a CertificateRequest is issued", func() {
+		issuer, secret := newReadyIssuer(namespace, "issuer", "issuer")
+		certificateRequest := newApprovedCertificateRequest(namespace, "issued", "issuer")
+		r, recorder := newFakeReconciler(fakeClock, issuer, secret, certificateRequest)
+
+		Expect(reconcileCertificateRequest(r, certificateRequest)).To(Equal(ctrl.Result{}))
+		Expect(certificateRequest.Status.Certificate).To(Equal(testCertificate))
+		expectEvent(recorder, corev1.EventTypeNormal, reasonIssued, "issued by keyvault test-vault")
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("records an Event when signing fails permanently", func() {
+		issuer, secret := newReadyIssuer(namespace, "issuer", signFailsIssuerName)
+		certificateRequest := newApprovedCertificateRequest(namespace, "sign-fails", "issuer")
+		r, recorder := newFakeReconciler(fakeClock, issuer, secret, certificateRequest)
+
+		Expect(reconcileCertificateRequest(r, certificateRequest)).To(Equal(ctrl.Result{}))
+		Expect(certificateRequest.Status.FailureTime).NotTo(BeNil())
+		expectEvent(recorder, corev1.EventTypeWarning, reasonFailed, "mock signing refused")
+		Expect(recorder.Events).NotTo(Receive())
+	})
+})
@@ -0,0 +1,43 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+// Reasons of the Events recorded on issuers and CertificateRequests. Events
+// with the same reason and message are aggregated by the recorder, so messages
+// only carry stable details.
+const (
+	// reasonCredentialsError is recorded when the auth Secret can't be read or
+	// doesn't contain valid credentials
+	reasonCredentialsError = "CredentialsError"
+	// reasonIssuerCheckFailed is recorded when the keyvault issuer, CA
+	// certificate or vault can't be accessed with the credentials
+	reasonIssuerCheckFailed = "IssuerCheckFailed"
+	// reasonIssuerReady is recorded when an issuer becomes ready
+	reasonIssuerReady = "IssuerReady"
+
+	// reasonCertificateCreated is recorded when a keyvault certificate
+	// operation is started for a CertificateRequest
+	reasonCertificateCreated = "CertificateCreated"
+	// reasonSignError is recorded when signing fails and will be retried
+	reasonSignError = "SignError"
+	// reasonIssued is recorded when the certificate is issued
+	reasonIssued = "Issued"
+	// reasonFailed is recorded when the CertificateRequest is marked as failed
+	reasonFailed = "Failed"
+	// reasonDenied is recorded when the CertificateRequest has been denied
+	reasonDenied = "Denied"
+)
@@ -23,19 +23,28 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	azureissuerv1alpha1 "github.com/aramase/azure-external-issuer/api/v1alpha1"
 	"github.com/aramase/azure-external-issuer/internal/issuer/signer"
 	issuerutil "github.com/aramase/azure-external-issuer/internal/issuer/util"
+	"github.com/aramase/azure-external-issuer/internal/metrics"
 )
 
 const (
 	issuerReadyConditionReason = "sample-issuer.IssuerController.Reconcile"
+
+	// authSecretNameField indexes issuers by the name of their auth Secret
+	authSecretNameField = "spec.authSecretName"
 )
 
 var (
@@ -48,11 +57,20 @@ type IssuerReconciler struct {
 	Kind                     string
 	ClusterResourceNamespace string
 	Scheme                   *runtime.Scheme
+	// HealthCheckerBuilder builds the HealthChecker that verifies the issuer,
+	// signer.NewHealthChecker for the registered backends
+	HealthCheckerBuilder signer.HealthCheckerBuilder
+	// SignerCache is shared with the CertificateRequestReconciler to reuse
+	// keyvault clients and tokens. A new signer is built on every reconcile
+	// if it is nil.
+	SignerCache *signer.Cache
+	Recorder    record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=azure-issuer.microsoft.com,resources=issuers;clusterissuers,verbs=get;list;watch
 // +kubebuilder:rbac:groups=azure-issuer.microsoft.com,resources=issuers/status;clusterissuers/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *IssuerReconciler) newIssuer() (client.Object, error) {
 	issuerGVK := azureissuerv1alpha1.GroupVersion.WithKind(r.Kind)
@@ -63,6 +81,15 @@ func (r *IssuerReconciler) newIssuer() (client.Object, error) {
 	return ro.(client.Object), nil
 }
 
+func (r *IssuerReconciler) newIssuerList() (client.ObjectList, error) {
+	issuerListGVK := azureissuerv1alpha1.GroupVersion.WithKind(r.Kind + "List")
+	ro, err := r.Scheme.New(issuerListGVK)
+	if err != nil {
+		return nil, err
+	}
+	return ro.(client.ObjectList), nil
+}
+
 func (r *IssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
 	log := ctrl.LoggerFrom(ctx)
 
@@ -76,6 +103,7 @@ func (r *IssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 			return ctrl.Result{}, fmt.Errorf("unexpected get error: %v", err)
 		}
 		log.Info("Issuer not found. Ignoring")
+		metrics.DeleteIssuer(r.Kind, req.NamespacedName)
 		return ctrl.Result{}, nil
 	}
 	issuerSpec, issuerStatus, err := issuerutil.GetSpecAndStatus(issuer)
@@ -89,6 +117,7 @@ func (r *IssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 		if err != nil {
 			issuerutil.SetReadyCondition(issuerStatus, azureissuerv1alpha1.ConditionFalse, issuerReadyConditionReason, err.Error())
 		}
+		metrics.SetIssuerReady(r.Kind, req.NamespacedName, issuerutil.IsReady(issuerStatus))
 		if updateErr := r.Status().Update(ctx, issuer); updateErr != nil {
 			err = utilerrors.NewAggregate([]error{err, updateErr})
 			result = ctrl.Result{}
@@ -116,26 +145,122 @@ func (r *IssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res
 
 	var secret corev1.Secret
 	if err := r.Get(ctx, secretName, &secret); err != nil {
-		return ctrl.Result{}, fmt.Errorf("%w, secret name: %s, reason: %v", errGetAuthSecret, secretName, err)
+		err = fmt.Errorf("%w, secret name: %s, reason: %v", errGetAuthSecret, secretName, err)
+		r.Recorder.Event(issuer, corev1.EventTypeWarning, reasonCredentialsError, err.Error())
+		return ctrl.Result{}, err
 	}
-	issuerClient, err := signer.NewSigner(secret.Data, issuerSpec.KeyvaultName)
+	checker, err := r.getHealthChecker(issuer, &secret, issuerSpec)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to get issuer client for %s: %v", issuerSpec.IssuerName, err)
+		err = fmt.Errorf("failed to get issuer client for %s: %v", issuerSpec.IssuerName, err)
+		r.Recorder.Event(issuer, corev1.EventTypeWarning, reasonCredentialsError, err.Error())
+		return ctrl.Result{}, err
 	}
-	if err = issuerClient.CheckIssuer(ctx, issuerSpec.IssuerName); err != nil {
-		return ctrl.Result{}, fmt.Errorf("failed to check if issuer %s exists: %v", issuerSpec.IssuerName, err)
+	if err = checker.CheckIssuer(ctx, *issuerSpec); err != nil {
+		// throttling says nothing about the issuer, keep its Ready condition
+		// and check again once keyvault accepts requests
+		if errors.Is(err, signer.ErrThrottled) {
+			retryAfter := throttledRetryAfter(err)
+			log.Info("Keyvault throttled the issuer check. Retrying later.", "reason", err.Error(), "retryAfter", retryAfter)
+			return ctrl.Result{RequeueAfter: retryAfter}, nil
+		}
+		// start over with a new client and token on the next check
+		if r.SignerCache != nil {
+			r.SignerCache.Delete(issuer.GetUID())
+		}
+		err = fmt.Errorf("failed to check issuer: %v", err)
+		r.Recorder.Event(issuer, corev1.EventTypeWarning, reasonIssuerCheckFailed, err.Error())
+		return ctrl.Result{}, err
 	}
 
+	if !issuerutil.IsReady(issuerStatus) {
+		r.Recorder.Eventf(issuer, corev1.EventTypeNormal, reasonIssuerReady, "Verified access to keyvault %s", issuerSpec.KeyvaultName)
+	}
 	issuerutil.SetReadyCondition(issuerStatus, azureissuerv1alpha1.ConditionTrue, issuerReadyConditionReason, "Success")
 	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
 }
 
+// getHealthChecker returns the HealthChecker of the issuer, reusing the cached
+// one while the auth Secret, the vault and the backend of the issuer are unchanged
+func (r *IssuerReconciler) getHealthChecker(issuer client.Object, secret *corev1.Secret, issuerSpec *azureissuerv1alpha1.IssuerSpec) (signer.HealthChecker, error) {
+	build := func() (signer.HealthChecker, error) {
+		return r.HealthCheckerBuilder(secret.Data, *issuerSpec)
+	}
+	if r.SignerCache == nil {
+		return build()
+	}
+	return r.SignerCache.GetHealthChecker(signerCacheKey(issuer, secret, issuerSpec), build)
+}
+
+// signerCacheKey identifies the signer of the issuer in the signer cache
+func signerCacheKey(issuer client.Object, secret *corev1.Secret, issuerSpec *azureissuerv1alpha1.IssuerSpec) signer.CacheKey {
+	return signer.CacheKey{
+		IssuerUID:             issuer.GetUID(),
+		SecretResourceVersion: secret.ResourceVersion,
+		VaultName:             issuerSpec.KeyvaultName,
+		Backend:               issuerSpec.BackendName(),
+	}
+}
+
 func (r *IssuerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.HealthCheckerBuilder == nil {
+		return errors.New("HealthCheckerBuilder is required")
+	}
 	issuerType, err := r.newIssuer()
 	if err != nil {
 		return err
 	}
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), issuerType, authSecretNameField, func(obj client.Object) []string {
+		issuerSpec, _, err := issuerutil.GetSpecAndStatus(obj)
+		if err != nil || issuerSpec.AuthSecretName == "" {
+			return nil
+		}
+		return []string{issuerSpec.AuthSecretName}
+	})
+	if err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(issuerType).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.issuersForSecret)).
 		Complete(r)
 }
+
+// issuersForSecret returns the issuers that use the Secret as auth Secret, so
+// credential changes are validated immediately. Issuers reference Secrets in
+// their own namespace, ClusterIssuers in the cluster resource namespace.
+func (r *IssuerReconciler) issuersForSecret(obj client.Object) []reconcile.Request {
+	log := ctrl.Log.WithName("controllers").WithName(r.Kind).WithValues("secret", client.ObjectKeyFromObject(obj))
+
+	issuerList, err := r.newIssuerList()
+	if err != nil {
+		log.Error(err, "Unrecognized issuer type")
+		return nil
+	}
+	opts := []client.ListOption{client.MatchingFields{authSecretNameField: obj.GetName()}}
+	switch issuerList.(type) {
+	case *azureissuerv1alpha1.IssuerList:
+		opts = append(opts, client.InNamespace(obj.GetNamespace()))
+	case *azureissuerv1alpha1.ClusterIssuerList:
+		if obj.GetNamespace() != r.ClusterResourceNamespace {
+			return nil
+		}
+	}
+	if err := r.List(context.Background(), issuerList, opts...); err != nil {
+		log.Error(err, "Unable to list issuers for Secret")
+		return nil
+	}
+	issuers, err := meta.ExtractList(issuerList)
+	if err != nil {
+		log.Error(err, "Unable to list issuers for Secret")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(issuers))
+	for _, issuer := range issuers {
+		issuerObj, ok := issuer.(client.Object)
+		if !ok {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(issuerObj)})
+	}
+	return requests
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	azureissuerv1alpha1 "github.com/aramase/azure-external-issuer/api/v1alpha1"
+	issuerutil "github.com/aramase/azure-external-issuer/internal/issuer/util"
+)
+
+// issuerReadyCondition returns the Ready condition of the Issuer or
+// ClusterIssuer, nil if it doesn't exist or has no Ready condition
+func issuerReadyCondition(issuer client.Object) func() *azureissuerv1alpha1.IssuerCondition {
+	return func() *azureissuerv1alpha1.IssuerCondition {
+		if err := k8sClient.Get(context.Background(), client.ObjectKeyFromObject(issuer), issuer); err != nil {
+			return nil
+		}
+		_, issuerStatus, err := issuerutil.GetSpecAndStatus(issuer)
+		if err != nil {
+			return nil
+		}
+		return issuerutil.GetReadyCondition(issuerStatus)
+	}
+}
+
+// beIssuerCondition matches an issuer condition with the status and a message
+// containing the substring
+func beIssuerCondition(status azureissuerv1alpha1.ConditionStatus, message string) types.GomegaMatcher {
+	return And(
+		Not(BeNil()),
+		WithTransform(func(c *azureissuerv1alpha1.IssuerCondition) azureissuerv1alpha1.ConditionStatus { return c.Status }, Equal(status)),
+		WithTransform(func(c *azureissuerv1alpha1.IssuerCondition) string { return c.Message }, ContainSubstring(message)),
+	)
+}
+
+// createReadyIssuer creates an Issuer with its auth Secret and waits for it to be ready
+func createReadyIssuer(namespace, name, issuerName string) *azureissuerv1alpha1.Issuer {
+	createAuthSecret(namespace, name+"-auth")
+	issuer := &azureissuerv1alpha1.Issuer{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       newIssuerSpec(issuerName, name+"-auth"),
+	}
+	Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+	Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionTrue, "Success"))
+	return issuer
+}
+
+var _ = Describe("IssuerReconciler", func() {
+	var namespace string
+
+	BeforeEach(func() {
+		namespace = createNamespace()
+	})
+
+	It("waits for the auth Secret before marking the Issuer ready", func() {
+		issuer := &azureissuerv1alpha1.Issuer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "missing-secret"},
+			Spec:       newIssuerSpec("issuer", "missing-secret-auth"),
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionFalse, errGetAuthSecret.Error()))
+
+		createAuthSecret(namespace, "missing-secret-auth")
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionTrue, "Success"))
+	})
+
+	It("marks the Issuer not ready when the issuer check fails", func() {
+		createAuthSecret(namespace, "check-auth")
+		issuer := &azureissuerv1alpha1.Issuer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "check"},
+			Spec:       newIssuerSpec(checkFailsIssuerName, "check-auth"),
+		}
+		Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionFalse, "mock issuer check failed"))
+	})
+
+	It("reads the auth Secret of a ClusterIssuer from the cluster resource namespace", func() {
+		issuer := &azureissuerv1alpha1.ClusterIssuer{
+			ObjectMeta: metav1.ObjectMeta{Name: namespace + "-cluster"},
+			Spec:       newIssuerSpec("issuer", namespace+"-auth"),
+		}
+		// a Secret with the same name outside of the cluster resource namespace is ignored
+		createAuthSecret(namespace, namespace+"-auth")
+		Expect(k8sClient.Create(context.Background(), issuer)).To(Succeed())
+		defer func() {
+			Expect(k8sClient.Delete(context.Background(), issuer)).To(Succeed())
+		}()
+
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionFalse, errGetAuthSecret.Error()))
+
+		createAuthSecret(testClusterResourceNamespace, namespace+"-auth")
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionTrue, "Success"))
+	})
+
+	It("checks the Issuer again when its auth Secret changes", func() {
+		issuer := createReadyIssuer(namespace, "rotated", "issuer")
+
+		// a Ready issuer is only checked again after minutes, so the change
+		// must be picked up through the Secret watch
+		setClientSecret(namespace, "rotated-auth", invalidClientSecret)
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionFalse, "mock credentials rejected"))
+
+		setClientSecret(namespace, "rotated-auth", "rotated-client-secret")
+		Eventually(issuerReadyCondition(issuer), timeout, interval).Should(beIssuerCondition(azureissuerv1alpha1.ConditionTrue, "Success"))
+	})
+
+	It("maps auth Secrets to the issuers using them", func() {
+		issuer := createReadyIssuer(namespace, "mapped", "issuer")
+		createReadyIssuer(namespace, "other", "issuer")
+		clusterIssuer := &azureissuerv1alpha1.ClusterIssuer{
+			ObjectMeta: metav1.ObjectMeta{Name: namespace + "-mapped"},
+			Spec:       newIssuerSpec("issuer", "mapped-auth"),
+		}
+		Expect(k8sClient.Create(context.Background(), clusterIssuer)).To(Succeed())
+		defer func() {
+			Expect(k8sClient.Delete(context.Background(), clusterIssuer)).To(Succeed())
+		}()
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "mapped-auth"}}
+
+		// the manager cache catches up with the created issuers eventually
+		Eventually(func() []reconcile.Request {
+			return issuerReconciler.issuersForSecret(secret)
+		}, timeout, interval).Should(ConsistOf(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(issuer)}))
+		// ClusterIssuers only read Secrets of the cluster resource namespace
+		Expect(clusterIssuerReconciler.issuersForSecret(secret)).To(BeEmpty())
+
+		clusterSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: testClusterResourceNamespace, Name: "mapped-auth"}}
+		Eventually(func() []reconcile.Request {
+			return clusterIssuerReconciler.issuersForSecret(clusterSecret)
+		}, timeout, interval).Should(ContainElement(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(clusterIssuer)}))
+		Expect(issuerReconciler.issuersForSecret(clusterSecret)).To(BeEmpty())
+	})
+})
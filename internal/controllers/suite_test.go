@@ -0,0 +1,326 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	azureissuerv1alpha1 "github.com/aramase/azure-external-issuer/api/v1alpha1"
+	"github.com/aramase/azure-external-issuer/internal/issuer/signer"
+	"github.com/aramase/azure-external-issuer/internal/testutil/fakekeyvault"
+	// +kubebuilder:scaffold:imports
+)
+
+// These tests use Ginkgo (BDD-style Go testing framework). Refer to
+// http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
+
+const (
+	// testClusterResourceNamespace is the cluster resource namespace of the
+	// controllers under test
+	testClusterResourceNamespace = "cluster-resources"
+
+	timeout  = 20 * time.Second
+	interval = 250 * time.Millisecond
+
+	// issuer names the mockSigner fails for
+	checkFailsIssuerName = "check-fails"
+	signFailsIssuerName  = "sign-fails"
+	// throttledIssuerName is the issuer name the mockSigner throttles the first
+	// request of each certificate for, for mockThrottleDelay
+	throttledIssuerName = "throttled"
+	mockThrottleDelay   = 3 * time.Second
+	// invalidClientSecret is the client secret the mockSigner rejects
+	invalidClientSecret = "invalid"
+	// issuer names the mockSigner starts a pending certificate operation for,
+	// the operation completes or fails respectively when it is polled
+	pendingIssuerName      = "pending"
+	pendingFailsIssuerName = "pending-fails"
+)
+
+var (
+	k8sClient   client.Client
+	testScheme  *runtime.Scheme
+	testEnv     *envtest.Environment
+	stopManager context.CancelFunc
+
+	// kvServer is the fake keyvault the issuers of its vaults are signed with
+	kvServer *fakekeyvault.Server
+
+	// the reconcilers run by the manager, their event mappings are also
+	// tested directly
+	issuerReconciler             *IssuerReconciler
+	clusterIssuerReconciler      *IssuerReconciler
+	certificateRequestReconciler *CertificateRequestReconciler
+
+	testCertificate = []byte("signed certificate")
+	testCA          = []byte("ca certificate")
+
+	// throttledUntil records until when the mockSigner throttles requests of
+	// each certificate name
+	throttledUntilMu sync.Mutex
+	throttledUntil   = map[string]time.Time{}
+)
+
+func TestAPIs(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecsWithDefaultAndCustomReporters(t,
+		"Controller Suite",
+		[]Reporter{printer.NewlineReporter{}})
+}
+
+var _ = BeforeSuite(func(done Done) {
+	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+
+	By("bootstrapping test environment")
+	testEnv = &envtest.Environment{
+		CRDDirectoryPaths: []string{
+			filepath.Join("..", "..", "config", "crd", "bases"),
+			filepath.Join("testdata", "crds"),
+		},
+	}
+
+	cfg, err := testEnv.Start()
+	Expect(err).ToNot(HaveOccurred())
+	Expect(cfg).ToNot(BeNil())
+
+	testScheme = runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+	Expect(cmapi.AddToScheme(testScheme)).To(Succeed())
+	Expect(azureissuerv1alpha1.AddToScheme(testScheme)).To(Succeed())
+	// +kubebuilder:scaffold:scheme
+
+	k8sClient, err = client.New(cfg, client.Options{Scheme: testScheme})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(k8sClient.Create(context.Background(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: testClusterResourceNamespace},
+	})).To(Succeed())
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             testScheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).ToNot(HaveOccurred())
+	kvServer = fakekeyvault.NewServer()
+	signer.SetHTTPTransport(kvServer.Transport())
+	signerCache := signer.NewCache()
+	issuerReconciler = &IssuerReconciler{
+		Kind:                     "Issuer",
+		ClusterResourceNamespace: testClusterResourceNamespace,
+		Client:                   mgr.GetClient(),
+		Scheme:                   mgr.GetScheme(),
+		HealthCheckerBuilder:     newTestHealthChecker,
+		SignerCache:              signerCache,
+		Recorder:                 mgr.GetEventRecorderFor("issuer-controller"),
+	}
+	Expect(issuerReconciler.SetupWithManager(mgr)).To(Succeed())
+	clusterIssuerReconciler = &IssuerReconciler{
+		Kind:                     "ClusterIssuer",
+		ClusterResourceNamespace: testClusterResourceNamespace,
+		Client:                   mgr.GetClient(),
+		Scheme:                   mgr.GetScheme(),
+		HealthCheckerBuilder:     newTestHealthChecker,
+		SignerCache:              signerCache,
+		Recorder:                 mgr.GetEventRecorderFor("clusterissuer-controller"),
+	}
+	Expect(clusterIssuerReconciler.SetupWithManager(mgr)).To(Succeed())
+	certificateRequestReconciler = &CertificateRequestReconciler{
+		Client:                   mgr.GetClient(),
+		Scheme:                   mgr.GetScheme(),
+		ClusterResourceNamespace: testClusterResourceNamespace,
+		Clock:                    clock.RealClock{},
+		CheckApprovedCondition:   true,
+		SignerBuilder:            newTestSigner,
+		SignerCache:              signerCache,
+		Recorder:                 mgr.GetEventRecorderFor("certificaterequests-controller"),
+	}
+	Expect(certificateRequestReconciler.SetupWithManager(mgr)).To(Succeed())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopManager = cancel
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+
+	close(done)
+}, 60)
+
+var _ = AfterSuite(func() {
+	By("tearing down the test environment")
+	if stopManager != nil {
+		stopManager()
+	}
+	Expect(testEnv.Stop()).To(Succeed())
+	if kvServer != nil {
+		signer.SetHTTPTransport(nil)
+		kvServer.Close()
+	}
+})
+
+// newTestSigner builds the signer of the backend for issuers of a vault of the
+// fake keyvault, and a mockSigner for other issuers
+func newTestSigner(credentials map[string][]byte, issuerSpec azureissuerv1alpha1.IssuerSpec) (signer.Signer, error) {
+	if kvServer.Vault(issuerSpec.KeyvaultName) != nil {
+		return signer.NewSigner(credentials, issuerSpec)
+	}
+	return newMockSigner(credentials, issuerSpec)
+}
+
+// newTestHealthChecker is the HealthCheckerBuilder counterpart of newTestSigner
+func newTestHealthChecker(credentials map[string][]byte, issuerSpec azureissuerv1alpha1.IssuerSpec) (signer.HealthChecker, error) {
+	if kvServer.Vault(issuerSpec.KeyvaultName) != nil {
+		return signer.NewHealthChecker(credentials, issuerSpec)
+	}
+	return newMockHealthChecker(credentials, issuerSpec)
+}
+
+// mockSigner signs every request with testCertificate, except for issuers
+// named checkFailsIssuerName and signFailsIssuerName. Requests of issuers
+// named throttledIssuerName are throttled at first, requests of issuers named
+// pendingIssuerName and pendingFailsIssuerName start a pending operation. The
+// issuer check fails for auth Secrets with the client secret
+// invalidClientSecret.
+type mockSigner struct {
+	credentials map[string][]byte
+}
+
+var (
+	_ signer.SignerBuilder        = newTestSigner
+	_ signer.HealthCheckerBuilder = newTestHealthChecker
+)
+
+func newMockSigner(map[string][]byte, azureissuerv1alpha1.IssuerSpec) (signer.Signer, error) {
+	return &mockSigner{}, nil
+}
+
+func newMockHealthChecker(credentials map[string][]byte, _ azureissuerv1alpha1.IssuerSpec) (signer.HealthChecker, error) {
+	return &mockSigner{credentials: credentials}, nil
+}
+
+func (s *mockSigner) CheckIssuer(_ context.Context, issuerSpec azureissuerv1alpha1.IssuerSpec) error {
+	if issuerSpec.IssuerName == checkFailsIssuerName {
+		return errors.New("mock issuer check failed")
+	}
+	if string(s.credentials["aadClientSecret"]) == invalidClientSecret {
+		return errors.New("mock credentials rejected")
+	}
+	return nil
+}
+
+func (s *mockSigner) Sign(_ context.Context, req signer.Request, issuerSpec azureissuerv1alpha1.IssuerSpec) (*signer.SignedCertificate, error) {
+	switch issuerSpec.IssuerName {
+	case signFailsIssuerName:
+		return nil, fmt.Errorf("%w: mock signing refused", signer.ErrPolicyViolation)
+	case throttledIssuerName:
+		throttledUntilMu.Lock()
+		until, ok := throttledUntil[req.Name]
+		if !ok {
+			until = time.Now().Add(mockThrottleDelay)
+			throttledUntil[req.Name] = until
+		}
+		throttledUntilMu.Unlock()
+		if delay := time.Until(until); delay > 0 {
+			return nil, &signer.Error{
+				Kind:       signer.ErrThrottled,
+				RetryAfter: delay,
+				Err:        errors.New("mock signing throttled"),
+			}
+		}
+	case pendingIssuerName, pendingFailsIssuerName:
+		// like keyvault, the operation belongs to the certificate name
+		operationID := mockOperationID(req.Name)
+		if req.OperationID == "" {
+			return nil, &signer.PendingError{OperationID: operationID, Details: "mock operation in progress"}
+		}
+		if req.OperationID != operationID {
+			return nil, fmt.Errorf("%w: mock operation %s not found", signer.ErrOperationFailed, req.OperationID)
+		}
+		if issuerSpec.IssuerName == pendingFailsIssuerName {
+			return nil, fmt.Errorf("%w: mock operation %s is cancelled", signer.ErrOperationFailed, req.OperationID)
+		}
+	}
+	return &signer.SignedCertificate{
+		Certificate: testCertificate,
+		CA:          testCA,
+	}, nil
+}
+
+// mockOperationID returns the ID of the operation the mockSigner starts for the
+// certificate name
+func mockOperationID(certificateName string) string {
+	return "operation-" + certificateName
+}
+
+// createNamespace creates a namespace for a test
+func createNamespace() string {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"},
+	}
+	Expect(k8sClient.Create(context.Background(), namespace)).To(Succeed())
+	return namespace.Name
+}
+
+// createAuthSecret creates an auth Secret the mockSigner and the fake keyvault
+// accept
+func createAuthSecret(namespace, name string) {
+	Expect(k8sClient.Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		StringData: map[string]string{"tenantID": "tenant", "aadClientID": "client-id", "aadClientSecret": "client-secret"},
+	})).To(Succeed())
+}
+
+// setClientSecret updates the client secret of an auth Secret
+func setClientSecret(namespace, name, clientSecret string) {
+	Eventually(func() error {
+		var secret corev1.Secret
+		if err := k8sClient.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: name}, &secret); err != nil {
+			return err
+		}
+		secret.Data["aadClientSecret"] = []byte(clientSecret)
+		return k8sClient.Update(context.Background(), &secret)
+	}, timeout, interval).Should(Succeed())
+}
+
+func newIssuerSpec(issuerName, authSecretName string) azureissuerv1alpha1.IssuerSpec {
+	return azureissuerv1alpha1.IssuerSpec{
+		KeyvaultName:   "test-vault",
+		IssuerName:     issuerName,
+		AuthSecretName: authSecretName,
+	}
+}
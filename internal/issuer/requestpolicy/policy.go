@@ -0,0 +1,235 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package requestpolicy enforces the request policy of issuers on CertificateRequests
+package requestpolicy
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+	"github.com/aramase/azure-external-issuer/internal/issuer/signer"
+)
+
+// ErrViolation is returned for CertificateRequests that violate the policy of
+// their issuer
+var ErrViolation = errors.New("certificate request violates the issuer policy")
+
+// defaultUsages are the usages signed for CertificateRequests without usages
+var defaultUsages = []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageKeyEncipherment}
+
+// Check returns an ErrViolation listing all violations of the policy by the
+// CertificateRequest, nil if the policy is nil or the CertificateRequest
+// complies with it. issuedDuration returns the validity the backend issues a
+// certificate requested for a duration with, the maximum duration is checked
+// against it. A nil issuedDuration issues certificates for the requested
+// duration.
+func Check(policy *v1alpha1.RequestPolicy, certificateRequest *cmapi.CertificateRequest, issuedDuration func(time.Duration) time.Duration) error {
+	if policy == nil {
+		return nil
+	}
+	csr, err := pki.DecodeX509CertificateRequestBytes(certificateRequest.Spec.Request)
+	if err != nil {
+		return fmt.Errorf("%w: failed to decode CSR: %v", ErrViolation, err)
+	}
+
+	var violations []string
+	if cn := csr.Subject.CommonName; cn != "" {
+		if ip := net.ParseIP(cn); ip != nil {
+			if !containsIP(policy.AllowedIPRanges, ip) {
+				violations = append(violations, fmt.Sprintf("common name %q is not an allowed IP address", cn))
+			}
+		} else if !matchAnyDNSName(policy.AllowedDNSNames, cn, policy.AllowWildcardNames) {
+			violations = append(violations, fmt.Sprintf("common name %q is not an allowed DNS name", cn))
+		}
+	}
+	for _, name := range csr.DNSNames {
+		if !matchAnyDNSName(policy.AllowedDNSNames, name, policy.AllowWildcardNames) {
+			violations = append(violations, fmt.Sprintf("DNS name %q is not allowed", name))
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if !containsIP(policy.AllowedIPRanges, ip) {
+			violations = append(violations, fmt.Sprintf("IP address %s is not allowed", ip))
+		}
+	}
+	for _, uri := range csr.URIs {
+		violations = append(violations, fmt.Sprintf("URI %q is not allowed", uri))
+	}
+	for _, email := range csr.EmailAddresses {
+		violations = append(violations, fmt.Sprintf("email address %q is not allowed", email))
+	}
+	// keyvault issues certificates for the user principal names of the CSR,
+	// which crypto/x509 doesn't parse
+	upns, err := signer.UserPrincipalNames(csr)
+	if err != nil {
+		violations = append(violations, err.Error())
+	}
+	for _, upn := range upns {
+		violations = append(violations, fmt.Sprintf("user principal name %q is not allowed", upn))
+	}
+	violations = append(violations, checkUsages(policy.AllowedUsages, certificateRequest)...)
+	if policy.MaxDuration != nil {
+		violations = append(violations, checkDuration(policy.MaxDuration.Duration, certificateRequest, issuedDuration)...)
+	}
+	for _, field := range policy.RequiredSubjectFields {
+		if !hasSubjectField(csr, field) {
+			violations = append(violations, fmt.Sprintf("subject field %s is required", field))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrViolation, strings.Join(violations, "; "))
+}
+
+// matchAnyDNSName returns true if the DNS name matches one of the patterns
+func matchAnyDNSName(patterns []string, name string, allowWildcards bool) bool {
+	for _, pattern := range patterns {
+		if matchDNSName(pattern, name, allowWildcards) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDNSName matches the labels of the DNS name with the labels of the
+// pattern. Names only match patterns with the same number of labels and never
+// have empty labels. A wildcard name only matches if wildcards are allowed,
+// its first label is `*` and the first label of the pattern is `*` too, so
+// globs never match the wildcard of a name.
+func matchDNSName(pattern, name string, allowWildcards bool) bool {
+	patternLabels := strings.Split(strings.ToLower(strings.TrimSuffix(pattern, ".")), ".")
+	nameLabels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	if len(patternLabels) != len(nameLabels) {
+		return false
+	}
+	for i, label := range nameLabels {
+		switch {
+		case label == "":
+			return false
+		case strings.Contains(label, "*"):
+			if !allowWildcards || i != 0 || label != "*" || patternLabels[i] != "*" {
+				return false
+			}
+		default:
+			if ok, err := path.Match(patternLabels[i], label); err != nil || !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containsIP returns true if one of the CIDRs contains the IP address. Invalid
+// CIDRs are rejected by the issuer webhook and never match.
+func containsIP(cidrs []string, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUsages returns the usages of the CertificateRequest that aren't allowed
+func checkUsages(allowed []cmapi.KeyUsage, certificateRequest *cmapi.CertificateRequest) []string {
+	if len(allowed) == 0 {
+		return nil
+	}
+	requested := certificateRequest.Spec.Usages
+	if len(requested) == 0 {
+		requested = defaultUsages
+	}
+	if certificateRequest.Spec.IsCA {
+		requested = append(requested[:len(requested):len(requested)], cmapi.UsageCertSign)
+	}
+	var violations []string
+	for _, usage := range requested {
+		if !hasUsage(allowed, usage) {
+			violations = append(violations, fmt.Sprintf("usage %q is not allowed", usage))
+		}
+	}
+	return violations
+}
+
+// checkDuration returns a violation if the certificate issued for the
+// CertificateRequest is valid for longer than the maximum duration
+func checkDuration(maxDuration time.Duration, certificateRequest *cmapi.CertificateRequest, issuedDuration func(time.Duration) time.Duration) []string {
+	duration := cmapi.DefaultCertificateDuration
+	if certificateRequest.Spec.Duration != nil && certificateRequest.Spec.Duration.Duration > 0 {
+		duration = certificateRequest.Spec.Duration.Duration
+	}
+	issued := duration
+	if issuedDuration != nil {
+		issued = issuedDuration(duration)
+	}
+	switch {
+	case duration > maxDuration:
+		return []string{fmt.Sprintf("duration %s exceeds the maximum duration of %s", duration, maxDuration)}
+	case issued > maxDuration:
+		return []string{fmt.Sprintf("duration %s is issued for %s by the issuer, exceeding the maximum duration of %s", duration, issued, maxDuration)}
+	default:
+		return nil
+	}
+}
+
+func hasUsage(usages []cmapi.KeyUsage, usage cmapi.KeyUsage) bool {
+	for _, u := range usages {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSubjectField returns true if the subject of the CSR sets the field
+func hasSubjectField(csr *x509.CertificateRequest, field v1alpha1.SubjectField) bool {
+	subject := csr.Subject
+	switch field {
+	case v1alpha1.SubjectCommonName:
+		return subject.CommonName != ""
+	case v1alpha1.SubjectOrganizations:
+		return len(subject.Organization) > 0
+	case v1alpha1.SubjectOrganizationalUnits:
+		return len(subject.OrganizationalUnit) > 0
+	case v1alpha1.SubjectCountries:
+		return len(subject.Country) > 0
+	case v1alpha1.SubjectProvinces:
+		return len(subject.Province) > 0
+	case v1alpha1.SubjectLocalities:
+		return len(subject.Locality) > 0
+	case v1alpha1.SubjectStreetAddresses:
+		return len(subject.StreetAddress) > 0
+	case v1alpha1.SubjectPostalCodes:
+		return len(subject.PostalCode) > 0
+	case v1alpha1.SubjectSerialNumber:
+		return subject.SerialNumber != ""
+	default:
+		return false
+	}
+}
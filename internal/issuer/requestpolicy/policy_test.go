@@ -0,0 +1,411 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package requestpolicy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// newCertificateRequest returns a CertificateRequest for a CSR created from
+// the template
+func newCertificateRequest(t *testing.T, template *x509.CertificateRequest) *cmapi.CertificateRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &cmapi.CertificateRequest{
+		Spec: cmapi.CertificateRequestSpec{
+			Request: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+		},
+	}
+}
+
+// otherNameExtension returns a subject alternative name extension with an
+// otherName of the type holding the UTF-8 value
+func otherNameExtension(t *testing.T, typeID asn1.ObjectIdentifier, value string) pkix.Extension {
+	t.Helper()
+	valueBytes, err := asn1.MarshalWithParams(value, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicitValue, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: valueBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeIDBytes, err := asn1.Marshal(typeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sans, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: append(typeIDBytes, explicitValue...)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: asn1.ObjectIdentifier{2, 5, 29, 17}, Value: sans}
+}
+
+// checkViolation fails the test unless err is an ErrViolation containing the
+// violation, or nil if the violation is empty
+func checkViolation(t *testing.T, err error, violation string) {
+	t.Helper()
+	if violation == "" {
+		if err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+		return
+	}
+	if !errors.Is(err, ErrViolation) {
+		t.Fatalf("Check() error = %v, want %v", err, ErrViolation)
+	}
+	if !strings.Contains(err.Error(), violation) {
+		t.Errorf("Check() error = %v, want violation %q", err, violation)
+	}
+}
+
+func TestMatchDNSName(t *testing.T) {
+	tests := []struct {
+		name           string
+		pattern        string
+		dnsName        string
+		allowWildcards bool
+		want           bool
+	}{
+		{name: "exact name", pattern: "www.example.com", dnsName: "www.example.com", want: true},
+		{name: "glob label", pattern: "*.example.com", dnsName: "www.example.com", want: true},
+		{name: "partial glob label", pattern: "api-*.example.com", dnsName: "api-eu.example.com", want: true},
+		{name: "fewer labels", pattern: "*.example.com", dnsName: "example.com"},
+		{name: "more labels", pattern: "*.example.com", dnsName: "a.b.example.com"},
+		{name: "case insensitive", pattern: "*.Example.COM", dnsName: "WWW.example.com", want: true},
+		{name: "trailing dot of the name", pattern: "*.example.com", dnsName: "www.example.com.", want: true},
+		{name: "trailing dot of the pattern", pattern: "*.example.com.", dnsName: "www.example.com", want: true},
+		{name: "empty label", pattern: "*.example.com", dnsName: ".example.com"},
+		{name: "empty label in the middle", pattern: "www.*.example.com", dnsName: "www..example.com"},
+		{name: "two trailing dots", pattern: "*.example.com", dnsName: "example.com.."},
+		{name: "wildcard name without allowing wildcards", pattern: "*.example.com", dnsName: "*.example.com"},
+		{name: "wildcard name", pattern: "*.example.com", dnsName: "*.example.com", allowWildcards: true, want: true},
+		{name: "wildcard name for a glob label", pattern: "w*.example.com", dnsName: "*.example.com", allowWildcards: true},
+		{name: "wildcard name for a literal label", pattern: "www.example.com", dnsName: "*.example.com", allowWildcards: true},
+		{name: "partial wildcard name", pattern: "*.example.com", dnsName: "w*.example.com", allowWildcards: true},
+		{name: "wildcard after the first label", pattern: "www.*.com", dnsName: "www.*.com", allowWildcards: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchDNSName(tt.pattern, tt.dnsName, tt.allowWildcards); got != tt.want {
+				t.Errorf("matchDNSName(%q, %q, %t) = %t, want %t", tt.pattern, tt.dnsName, tt.allowWildcards, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	uri, err := url.Parse("spiffe://example.com/workload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	namesPolicy := &v1alpha1.RequestPolicy{
+		AllowedDNSNames: []string{"*.example.com"},
+		AllowedIPRanges: []string{"10.0.0.0/8", "fd00::/8"},
+	}
+	tests := []struct {
+		name      string
+		policy    *v1alpha1.RequestPolicy
+		csr       x509.CertificateRequest
+		violation string
+	}{
+		{
+			name: "no policy",
+			csr:  x509.CertificateRequest{DNSNames: []string{"www.example.org"}},
+		},
+		{
+			name:   "allowed names",
+			policy: namesPolicy,
+			csr: x509.CertificateRequest{
+				Subject:     pkix.Name{CommonName: "www.example.com"},
+				DNSNames:    []string{"www.example.com", "API.example.com."},
+				IPAddresses: []net.IP{net.ParseIP("10.1.2.3"), net.ParseIP("fd00::1")},
+			},
+		},
+		{
+			name:      "DNS name not allowed",
+			policy:    namesPolicy,
+			csr:       x509.CertificateRequest{DNSNames: []string{"www.example.org"}},
+			violation: `DNS name "www.example.org" is not allowed`,
+		},
+		{
+			name:      "wildcard DNS name not allowed",
+			policy:    namesPolicy,
+			csr:       x509.CertificateRequest{DNSNames: []string{"*.example.com"}},
+			violation: `DNS name "*.example.com" is not allowed`,
+		},
+		{
+			name: "wildcard DNS name allowed",
+			policy: &v1alpha1.RequestPolicy{
+				AllowedDNSNames:    []string{"*.example.com"},
+				AllowWildcardNames: true,
+			},
+			csr: x509.CertificateRequest{
+				Subject:  pkix.Name{CommonName: "*.example.com"},
+				DNSNames: []string{"*.example.com"},
+			},
+		},
+		{
+			name:      "common name not allowed",
+			policy:    namesPolicy,
+			csr:       x509.CertificateRequest{Subject: pkix.Name{CommonName: "www.example.org"}},
+			violation: `common name "www.example.org" is not an allowed DNS name`,
+		},
+		{
+			name:      "common name without allowed DNS names",
+			policy:    &v1alpha1.RequestPolicy{},
+			csr:       x509.CertificateRequest{Subject: pkix.Name{CommonName: "www.example.com"}},
+			violation: `common name "www.example.com" is not an allowed DNS name`,
+		},
+		{
+			name:   "IP address common name",
+			policy: namesPolicy,
+			csr:    x509.CertificateRequest{Subject: pkix.Name{CommonName: "10.0.0.1"}},
+		},
+		{
+			name:      "IP address common name not allowed",
+			policy:    namesPolicy,
+			csr:       x509.CertificateRequest{Subject: pkix.Name{CommonName: "192.168.0.1"}},
+			violation: `common name "192.168.0.1" is not an allowed IP address`,
+		},
+		{
+			name:      "IP address outside of the CIDRs",
+			policy:    namesPolicy,
+			csr:       x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("192.168.0.1")}},
+			violation: "IP address 192.168.0.1 is not allowed",
+		},
+		{
+			name:      "IPv6 address outside of the CIDRs",
+			policy:    namesPolicy,
+			csr:       x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("2001:db8::1")}},
+			violation: "IP address 2001:db8::1 is not allowed",
+		},
+		{
+			name:      "IP address without allowed CIDRs",
+			policy:    &v1alpha1.RequestPolicy{},
+			csr:       x509.CertificateRequest{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			violation: "IP address 10.0.0.1 is not allowed",
+		},
+		{
+			name:      "URI",
+			policy:    namesPolicy,
+			csr:       x509.CertificateRequest{URIs: []*url.URL{uri}},
+			violation: `URI "spiffe://example.com/workload" is not allowed`,
+		},
+		{
+			name:      "email address",
+			policy:    namesPolicy,
+			csr:       x509.CertificateRequest{EmailAddresses: []string{"admin@example.com"}},
+			violation: `email address "admin@example.com" is not allowed`,
+		},
+		{
+			name:   "user principal name",
+			policy: namesPolicy,
+			csr: x509.CertificateRequest{ExtraExtensions: []pkix.Extension{
+				otherNameExtension(t, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}, "user@example.com"),
+			}},
+			violation: `user principal name "user@example.com" is not allowed`,
+		},
+		{
+			name:   "otherName of another type",
+			policy: namesPolicy,
+			csr: x509.CertificateRequest{ExtraExtensions: []pkix.Extension{
+				otherNameExtension(t, asn1.ObjectIdentifier{1, 2, 3, 4}, "value"),
+			}},
+			violation: "otherName subject alternative name of type 1.2.3.4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificateRequest := newCertificateRequest(t, &tt.csr)
+			checkViolation(t, Check(tt.policy, certificateRequest, nil), tt.violation)
+		})
+	}
+}
+
+func TestCheckUsages(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   []cmapi.KeyUsage
+		usages    []cmapi.KeyUsage
+		isCA      bool
+		violation string
+	}{
+		{
+			name:   "all usages allowed",
+			usages: []cmapi.KeyUsage{cmapi.UsageCodeSigning},
+		},
+		{
+			name:    "default usages",
+			allowed: []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageKeyEncipherment},
+		},
+		{
+			name:      "default usages not allowed",
+			allowed:   []cmapi.KeyUsage{cmapi.UsageDigitalSignature},
+			violation: `usage "key encipherment" is not allowed`,
+		},
+		{
+			name:    "requested usages",
+			allowed: []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageServerAuth},
+			usages:  []cmapi.KeyUsage{cmapi.UsageServerAuth},
+		},
+		{
+			name:      "requested usage not allowed",
+			allowed:   []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageServerAuth},
+			usages:    []cmapi.KeyUsage{cmapi.UsageClientAuth},
+			violation: `usage "client auth" is not allowed`,
+		},
+		{
+			name:      "CA without cert sign",
+			allowed:   []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageKeyEncipherment},
+			isCA:      true,
+			violation: `usage "cert sign" is not allowed`,
+		},
+		{
+			name:    "CA with cert sign",
+			allowed: []cmapi.KeyUsage{cmapi.UsageDigitalSignature, cmapi.UsageKeyEncipherment, cmapi.UsageCertSign},
+			isCA:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificateRequest := newCertificateRequest(t, &x509.CertificateRequest{})
+			certificateRequest.Spec.Usages = tt.usages
+			certificateRequest.Spec.IsCA = tt.isCA
+			policy := &v1alpha1.RequestPolicy{AllowedUsages: tt.allowed}
+			checkViolation(t, Check(policy, certificateRequest, nil), tt.violation)
+		})
+	}
+}
+
+func TestCheckDuration(t *testing.T) {
+	day := 24 * time.Hour
+	// roundToMonths issues certificates for calendar months, like keyvault
+	roundToMonths := func(duration time.Duration) time.Duration {
+		months := (duration + 30*day - 1) / (30 * day)
+		return months * 31 * day
+	}
+	tests := []struct {
+		name           string
+		maxDuration    time.Duration
+		duration       *metav1.Duration
+		issuedDuration func(time.Duration) time.Duration
+		violation      string
+	}{
+		{
+			name:        "default duration",
+			maxDuration: 90 * day,
+		},
+		{
+			name:        "default duration exceeds the maximum",
+			maxDuration: 30 * day,
+			violation:   "duration 2160h0m0s exceeds the maximum duration of 720h0m0s",
+		},
+		{
+			name:        "zero duration requests the default",
+			maxDuration: 30 * day,
+			duration:    &metav1.Duration{},
+			violation:   "duration 2160h0m0s exceeds the maximum duration of 720h0m0s",
+		},
+		{
+			name:        "requested duration",
+			maxDuration: 30 * day,
+			duration:    &metav1.Duration{Duration: 7 * day},
+		},
+		{
+			name:        "requested duration exceeds the maximum",
+			maxDuration: 30 * day,
+			duration:    &metav1.Duration{Duration: 31 * day},
+			violation:   "duration 744h0m0s exceeds the maximum duration of 720h0m0s",
+		},
+		{
+			name:           "issued duration",
+			maxDuration:    31 * day,
+			duration:       &metav1.Duration{Duration: 7 * day},
+			issuedDuration: roundToMonths,
+		},
+		{
+			name:           "issued duration exceeds the maximum",
+			maxDuration:    30 * day,
+			duration:       &metav1.Duration{Duration: 7 * day},
+			issuedDuration: roundToMonths,
+			violation:      "duration 168h0m0s is issued for 744h0m0s by the issuer, exceeding the maximum duration of 720h0m0s",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificateRequest := newCertificateRequest(t, &x509.CertificateRequest{})
+			certificateRequest.Spec.Duration = tt.duration
+			policy := &v1alpha1.RequestPolicy{MaxDuration: &metav1.Duration{Duration: tt.maxDuration}}
+			checkViolation(t, Check(policy, certificateRequest, tt.issuedDuration), tt.violation)
+		})
+	}
+}
+
+func TestCheckRequiredSubjectFields(t *testing.T) {
+	tests := []struct {
+		field   v1alpha1.SubjectField
+		subject pkix.Name
+	}{
+		{field: v1alpha1.SubjectCommonName, subject: pkix.Name{CommonName: "www.example.com"}},
+		{field: v1alpha1.SubjectOrganizations, subject: pkix.Name{Organization: []string{"Example"}}},
+		{field: v1alpha1.SubjectOrganizationalUnits, subject: pkix.Name{OrganizationalUnit: []string{"Engineering"}}},
+		{field: v1alpha1.SubjectCountries, subject: pkix.Name{Country: []string{"US"}}},
+		{field: v1alpha1.SubjectProvinces, subject: pkix.Name{Province: []string{"Washington"}}},
+		{field: v1alpha1.SubjectLocalities, subject: pkix.Name{Locality: []string{"Redmond"}}},
+		{field: v1alpha1.SubjectStreetAddresses, subject: pkix.Name{StreetAddress: []string{"1 Example Way"}}},
+		{field: v1alpha1.SubjectPostalCodes, subject: pkix.Name{PostalCode: []string{"98052"}}},
+		{field: v1alpha1.SubjectSerialNumber, subject: pkix.Name{SerialNumber: "1234"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.field), func(t *testing.T) {
+			policy := &v1alpha1.RequestPolicy{
+				AllowedDNSNames:       []string{"*.example.com"},
+				RequiredSubjectFields: []v1alpha1.SubjectField{tt.field},
+			}
+			certificateRequest := newCertificateRequest(t, &x509.CertificateRequest{Subject: tt.subject})
+			checkViolation(t, Check(policy, certificateRequest, nil), "")
+
+			certificateRequest = newCertificateRequest(t, &x509.CertificateRequest{})
+			checkViolation(t, Check(policy, certificateRequest, nil), "subject field "+string(tt.field)+" is required")
+		})
+	}
+}
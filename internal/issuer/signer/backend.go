@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// BackendFactory builds the Signer of a backend from the credentials in the
+// auth Secret and the issuer
+type BackendFactory func(creds map[string][]byte, issuerSpec v1alpha1.IssuerSpec) (Signer, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFactory{}
+)
+
+// RegisterBackend makes a backend available to issuers under the name. It
+// panics if a backend is registered twice with the same name.
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("signer backend %s registered twice", name))
+	}
+	backends[name] = factory
+}
+
+// SignerBuilder builds the Signer of an issuer from the credentials in its auth
+// Secret. NewSigner is the SignerBuilder of the registered backends.
+type SignerBuilder func(creds map[string][]byte, issuerSpec v1alpha1.IssuerSpec) (Signer, error)
+
+// HealthCheckerBuilder builds the HealthChecker of an issuer from the
+// credentials in its auth Secret. NewHealthChecker is the HealthCheckerBuilder
+// of the registered backends.
+type HealthCheckerBuilder func(creds map[string][]byte, issuerSpec v1alpha1.IssuerSpec) (HealthChecker, error)
+
+var (
+	_ SignerBuilder        = NewSigner
+	_ HealthCheckerBuilder = NewHealthChecker
+)
+
+// NewSigner returns the Signer of the backend selected by the issuer
+func NewSigner(creds map[string][]byte, issuerSpec v1alpha1.IssuerSpec) (Signer, error) {
+	name := issuerSpec.BackendName()
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signer backend %q", name)
+	}
+	return factory(creds, issuerSpec)
+}
+
+// NewHealthChecker returns the Signer of the backend selected by the issuer as
+// a HealthChecker
+func NewHealthChecker(creds map[string][]byte, issuerSpec v1alpha1.IssuerSpec) (HealthChecker, error) {
+	return NewSigner(creds, issuerSpec)
+}
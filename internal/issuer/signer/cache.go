@@ -0,0 +1,148 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// cacheTTL is how long an unused signer is kept in the cache, so signers of
+// deleted issuers are eventually released
+const cacheTTL = time.Hour
+
+// CacheKey identifies the signer of an issuer. A signer only depends on the
+// credentials in the auth Secret, the vault and the backend, so a new signer is
+// built when the Secret, the vault name or the backend changes.
+type CacheKey struct {
+	// IssuerUID is the UID of the Issuer or ClusterIssuer
+	IssuerUID types.UID
+	// SecretResourceVersion is the resourceVersion of the auth Secret
+	SecretResourceVersion string
+	// VaultName is the name of the vault of the issuer
+	VaultName string
+	// Backend is the signer backend of the issuer
+	Backend string
+}
+
+// cacheID identifies a cache entry. The HealthChecker and the Signer of an
+// issuer are built by different builders, so they are cached separately.
+type cacheID struct {
+	issuerUID types.UID
+	signer    bool
+}
+
+// cacheEntry is a cached value. The value and err are set before ready is
+// closed, callers of the entry wait for the first caller to build it.
+type cacheEntry struct {
+	key      CacheKey
+	lastUsed time.Time
+	ready    chan struct{}
+	value    interface{}
+	err      error
+}
+
+// Cache reuses signers, along with their authorized keyvault clients and
+// tokens, across reconciles. It is shared by the issuer and CertificateRequest
+// controllers, which cache the HealthCheckers and the Signers of issuers
+// respectively. It is safe for concurrent use. Values are built outside of
+// the lock, so acquiring a token for one issuer doesn't hold back the others.
+type Cache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[cacheID]*cacheEntry
+}
+
+// NewCache returns an empty signer cache
+func NewCache() *Cache {
+	return &Cache{
+		now:     time.Now,
+		entries: map[cacheID]*cacheEntry{},
+	}
+}
+
+// GetHealthChecker returns the cached HealthChecker for the key, building a new
+// one if the issuer has none or its Secret, vault or backend changed
+func (c *Cache) GetHealthChecker(key CacheKey, build func() (HealthChecker, error)) (HealthChecker, error) {
+	value, err := c.get(cacheID{issuerUID: key.IssuerUID}, key, func() (interface{}, error) {
+		return build()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(HealthChecker), nil
+}
+
+// GetSigner returns the cached Signer for the key, building a new one if the
+// issuer has none or its Secret, vault or backend changed
+func (c *Cache) GetSigner(key CacheKey, build func() (Signer, error)) (Signer, error) {
+	value, err := c.get(cacheID{issuerUID: key.IssuerUID, signer: true}, key, func() (interface{}, error) {
+		return build()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(Signer), nil
+}
+
+// get returns the value of the entry, building it if there is none for the
+// key. Concurrent callers for the same key share a single build.
+func (c *Cache) get(id cacheID, key CacheKey, build func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	now := c.now()
+	for id, entry := range c.entries {
+		if now.Sub(entry.lastUsed) > cacheTTL {
+			delete(c.entries, id)
+		}
+	}
+	entry, ok := c.entries[id]
+	if ok && entry.key == key {
+		entry.lastUsed = now
+		c.mu.Unlock()
+		<-entry.ready
+		return entry.value, entry.err
+	}
+	entry = &cacheEntry{
+		key:      key,
+		lastUsed: now,
+		ready:    make(chan struct{}),
+	}
+	c.entries[id] = entry
+	c.mu.Unlock()
+
+	entry.value, entry.err = build()
+	close(entry.ready)
+	if entry.err != nil {
+		// build again on the next call, unless the entry was replaced meanwhile
+		c.mu.Lock()
+		if c.entries[id] == entry {
+			delete(c.entries, id)
+		}
+		c.mu.Unlock()
+	}
+	return entry.value, entry.err
+}
+
+// Delete removes the HealthChecker and the Signer of the issuer from the cache
+func (c *Cache) Delete(issuerUID types.UID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, cacheID{issuerUID: issuerUID})
+	delete(c.entries, cacheID{issuerUID: issuerUID, signer: true})
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// cachedSigner is a Signer told apart from others by its pointer
+type cachedSigner struct {
+	build int
+}
+
+func (s *cachedSigner) CheckIssuer(context.Context, v1alpha1.IssuerSpec) error {
+	return nil
+}
+
+func (s *cachedSigner) Sign(context.Context, Request, v1alpha1.IssuerSpec) (*SignedCertificate, error) {
+	return nil, nil
+}
+
+// countingBuilder returns a builder of new cachedSigners and the number of
+// signers it built
+func countingBuilder() (func() (Signer, error), *int32) {
+	var builds int32
+	return func() (Signer, error) {
+		return &cachedSigner{build: int(atomic.AddInt32(&builds, 1))}, nil
+	}, &builds
+}
+
+func TestCacheGetSigner(t *testing.T) {
+	key := CacheKey{
+		IssuerUID:             "issuer",
+		SecretResourceVersion: "1",
+		VaultName:             "vault",
+		Backend:               v1alpha1.BackendKeyVault,
+	}
+	tests := []struct {
+		name        string
+		change      func(key CacheKey) CacheKey
+		wantRebuild bool
+	}{
+		{
+			name:   "unchanged",
+			change: func(key CacheKey) CacheKey { return key },
+		},
+		{
+			name: "secret resourceVersion changed",
+			change: func(key CacheKey) CacheKey {
+				key.SecretResourceVersion = "2"
+				return key
+			},
+			wantRebuild: true,
+		},
+		{
+			name: "vault changed",
+			change: func(key CacheKey) CacheKey {
+				key.VaultName = "other"
+				return key
+			},
+			wantRebuild: true,
+		},
+		{
+			name: "backend changed",
+			change: func(key CacheKey) CacheKey {
+				key.Backend = v1alpha1.BackendKeyVaultCA
+				return key
+			},
+			wantRebuild: true,
+		},
+		{
+			name: "other issuer",
+			change: func(key CacheKey) CacheKey {
+				key.IssuerUID = "other"
+				return key
+			},
+			wantRebuild: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache()
+			build, builds := countingBuilder()
+			first, err := c.GetSigner(key, build)
+			if err != nil {
+				t.Fatalf("GetSigner() error = %v", err)
+			}
+			second, err := c.GetSigner(tt.change(key), build)
+			if err != nil {
+				t.Fatalf("GetSigner() error = %v", err)
+			}
+			if rebuilt := first != second; rebuilt != tt.wantRebuild {
+				t.Errorf("rebuilt = %v, want %v", rebuilt, tt.wantRebuild)
+			}
+			wantBuilds := int32(1)
+			if tt.wantRebuild {
+				wantBuilds = 2
+			}
+			if *builds != wantBuilds {
+				t.Errorf("builds = %d, want %d", *builds, wantBuilds)
+			}
+			if !tt.wantRebuild {
+				return
+			}
+			// the replaced signer isn't returned for the old key either
+			third, err := c.GetSigner(key, build)
+			if err != nil {
+				t.Fatalf("GetSigner() error = %v", err)
+			}
+			if tt.change(key).IssuerUID == key.IssuerUID && third == first {
+				t.Errorf("GetSigner() returned the replaced signer")
+			}
+		})
+	}
+}
+
+func TestCacheHealthCheckerAndSigner(t *testing.T) {
+	c := NewCache()
+	key := CacheKey{IssuerUID: "issuer", SecretResourceVersion: "1", VaultName: "vault"}
+	build, builds := countingBuilder()
+	checker, err := c.GetHealthChecker(key, func() (HealthChecker, error) {
+		return build()
+	})
+	if err != nil {
+		t.Fatalf("GetHealthChecker() error = %v", err)
+	}
+	s, err := c.GetSigner(key, build)
+	if err != nil {
+		t.Fatalf("GetSigner() error = %v", err)
+	}
+	if checker == HealthChecker(s) {
+		t.Errorf("GetSigner() returned the cached HealthChecker")
+	}
+	if again, _ := c.GetSigner(key, build); again != s {
+		t.Errorf("GetSigner() didn't reuse the cached Signer")
+	}
+	if *builds != 2 {
+		t.Errorf("builds = %d, want 2", *builds)
+	}
+}
+
+func TestCacheBuildFailure(t *testing.T) {
+	c := NewCache()
+	key := CacheKey{IssuerUID: "issuer", SecretResourceVersion: "1"}
+	errBuild := errors.New("build failed")
+	if _, err := c.GetSigner(key, func() (Signer, error) { return nil, errBuild }); !errors.Is(err, errBuild) {
+		t.Fatalf("GetSigner() error = %v, want %v", err, errBuild)
+	}
+	build, builds := countingBuilder()
+	if _, err := c.GetSigner(key, build); err != nil {
+		t.Fatalf("GetSigner() error = %v", err)
+	}
+	if *builds != 1 {
+		t.Errorf("failed build was cached, builds = %d, want 1", *builds)
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	c := NewCache()
+	key := CacheKey{IssuerUID: "issuer", SecretResourceVersion: "1"}
+	build, builds := countingBuilder()
+	checkerBuild := func() (HealthChecker, error) { return build() }
+	if _, err := c.GetSigner(key, build); err != nil {
+		t.Fatalf("GetSigner() error = %v", err)
+	}
+	if _, err := c.GetHealthChecker(key, checkerBuild); err != nil {
+		t.Fatalf("GetHealthChecker() error = %v", err)
+	}
+	c.Delete(key.IssuerUID)
+	if _, err := c.GetSigner(key, build); err != nil {
+		t.Fatalf("GetSigner() error = %v", err)
+	}
+	if _, err := c.GetHealthChecker(key, checkerBuild); err != nil {
+		t.Fatalf("GetHealthChecker() error = %v", err)
+	}
+	if *builds != 4 {
+		t.Errorf("builds = %d, want 4", *builds)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Now()
+	c := NewCache()
+	c.now = func() time.Time { return now }
+	key := CacheKey{IssuerUID: "issuer", SecretResourceVersion: "1"}
+	build, builds := countingBuilder()
+	if _, err := c.GetSigner(key, build); err != nil {
+		t.Fatalf("GetSigner() error = %v", err)
+	}
+	now = now.Add(cacheTTL)
+	if _, err := c.GetSigner(key, build); err != nil {
+		t.Fatalf("GetSigner() error = %v", err)
+	}
+	if *builds != 1 {
+		t.Errorf("signer used within the TTL was rebuilt, builds = %d, want 1", *builds)
+	}
+	now = now.Add(cacheTTL + time.Second)
+	if _, err := c.GetSigner(key, build); err != nil {
+		t.Fatalf("GetSigner() error = %v", err)
+	}
+	if *builds != 2 {
+		t.Errorf("expired signer was reused, builds = %d, want 2", *builds)
+	}
+}
+
+func TestCacheConcurrentBuilds(t *testing.T) {
+	c := NewCache()
+	slow := CacheKey{IssuerUID: "slow", SecretResourceVersion: "1"}
+	fast := CacheKey{IssuerUID: "fast", SecretResourceVersion: "1"}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var slowBuilds int32
+	slowBuild := func() (Signer, error) {
+		if atomic.AddInt32(&slowBuilds, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return &cachedSigner{}, nil
+	}
+
+	const callers = 5
+	signers := make([]Signer, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := c.GetSigner(slow, slowBuild)
+			if err != nil {
+				t.Errorf("GetSigner() error = %v", err)
+			}
+			signers[i] = s
+		}(i)
+	}
+	<-started
+
+	// a slow build for one issuer doesn't block the signers of other issuers
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		build, _ := countingBuilder()
+		if _, err := c.GetSigner(fast, build); err != nil {
+			t.Errorf("GetSigner() error = %v", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("GetSigner() for another issuer blocked on a slow build")
+	}
+
+	close(release)
+	wg.Wait()
+	if slowBuilds != 1 {
+		t.Errorf("concurrent callers built %d signers, want 1", slowBuilds)
+	}
+	for i := range signers {
+		if signers[i] != signers[0] {
+			t.Errorf("caller %d got a different signer", i)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"golang.org/x/crypto/pkcs12"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// maxChainLength is the maximum number of certificates followed when
+	// resolving the issuing chain of a certificate
+	maxChainLength = 10
+	// maxIssuerCertificateSize is the maximum size of an issuer certificate
+	// downloaded from an AIA URL
+	maxIssuerCertificateSize = 64 * 1024
+
+	contentTypePKCS12 = "application/x-pkcs12"
+	contentTypePEM    = "application/x-pem-file"
+)
+
+// aiaClient is used to download issuer certificates from the AIA URLs
+var aiaClient = &http.Client{Timeout: 10 * time.Second}
+
+// SignedCertificate holds the PEM encoded certificate issued for a CertificateRequest
+type SignedCertificate struct {
+	// Certificate is the signed certificate followed by the intermediate
+	// certificates of the issuing chain
+	Certificate []byte
+	// CA is the root certificate of the issuing chain. If the root is not known,
+	// it is the top most certificate of the chain.
+	CA []byte
+}
+
+// newSignedCertificate orders the certificates from the leaf to the root and splits
+// the chain into the certificate with its intermediates and the CA
+func newSignedCertificate(leaf *x509.Certificate, certs []*x509.Certificate) *SignedCertificate {
+	chain := []*x509.Certificate{leaf}
+	for current := leaf; !isSelfSigned(current) && len(chain) < maxChainLength; {
+		issuer := findIssuer(current, certs)
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+
+	result := &SignedCertificate{}
+	for i, cert := range chain {
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		last := i == len(chain)-1
+		// a self-signed root isn't part of the chain served with the certificate
+		if i == 0 || !last || !isSelfSigned(cert) {
+			result.Certificate = append(result.Certificate, certPEM...)
+		}
+		if last {
+			result.CA = certPEM
+		}
+	}
+	return result
+}
+
+// findIssuer returns the certificate that signed cert
+func findIssuer(cert *x509.Certificate, certs []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range certs {
+		if bytes.Equal(candidate.Raw, cert.Raw) {
+			continue
+		}
+		if !bytes.Equal(cert.RawIssuer, candidate.RawSubject) {
+			continue
+		}
+		if cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// isSelfSigned returns true if the certificate is signed by its own key
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// resolveChain returns the issuing chain of the certificate. The certificates are
+// read from the keyvault secret backing the certificate and any missing issuers
+// are downloaded from the AIA URLs in the certificates.
+//
+// Reading the secret returns the private key of the certificate along with the
+// chain, so it requires the secrets/get permission on the vault. Without it the
+// chain is only built from the AIA URLs, which keyvault issuers like private
+// CAs may not set, and cert-manager gets the certificate without its issuers.
+func (s *caSigner) resolveChain(ctx context.Context, leaf *x509.Certificate, secretID string) []*x509.Certificate {
+	var certs []*x509.Certificate
+	if secretID != "" {
+		secretCerts, err := s.getSecretCertificates(ctx, secretID)
+		if err != nil {
+			log.FromContext(ctx).Info("Unable to read the issuing chain from the keyvault secret, falling back to the AIA URLs of the certificate.",
+				"secret", secretID, "reason", err.Error())
+		}
+		certs = append(certs, secretCerts...)
+	}
+
+	current := leaf
+	for i := 0; i < maxChainLength && !isSelfSigned(current); i++ {
+		issuer := findIssuer(current, certs)
+		if issuer == nil {
+			issuer = downloadIssuer(ctx, current)
+			if issuer == nil {
+				break
+			}
+			certs = append(certs, issuer)
+		}
+		current = issuer
+	}
+	return certs
+}
+
+// getSecretCertificates returns the certificates in the PFX or PEM content of the
+// keyvault secret backing a certificate
+func (s *caSigner) getSecretCertificates(ctx context.Context, secretID string) ([]*x509.Certificate, error) {
+	name, version, err := parseObjectID(secretID, "secrets")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.baseClient.GetSecret(ctx, s.vaultURL, name, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %w", name, keyvaultError(err))
+	}
+	if secret.Value == nil {
+		return nil, fmt.Errorf("secret %s has no value", name)
+	}
+
+	var blocks []*pem.Block
+	switch contentType := strings.ToLower(to.String(secret.ContentType)); contentType {
+	case contentTypePKCS12:
+		pfx, err := base64.StdEncoding.DecodeString(*secret.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PFX content of secret %s: %v", name, err)
+		}
+		if blocks, err = pkcs12.ToPEM(pfx, ""); err != nil {
+			return nil, fmt.Errorf("failed to parse PFX content of secret %s: %v", name, err)
+		}
+	case contentTypePEM:
+		rest := []byte(*secret.Value)
+		for {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			blocks = append(blocks, block)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type %q of secret %s", contentType, name)
+	}
+
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in secret %s: %v", name, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// downloadIssuer returns the issuer of the certificate from its AIA URLs, or nil
+// if it can't be downloaded
+func downloadIssuer(ctx context.Context, cert *x509.Certificate) *x509.Certificate {
+	for _, issuerURL := range cert.IssuingCertificateURL {
+		issuer, err := downloadCertificate(ctx, issuerURL)
+		if err != nil {
+			continue
+		}
+		if cert.CheckSignatureFrom(issuer) == nil {
+			return issuer
+		}
+	}
+	return nil
+}
+
+// downloadCertificate downloads a DER or PEM encoded certificate
+func downloadCertificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := aiaClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d downloading %s", resp.StatusCode, certURL)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIssuerCertificateSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxIssuerCertificateSize {
+		return nil, fmt.Errorf("certificate downloaded from %s exceeds %d bytes", certURL, maxIssuerCertificateSize)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
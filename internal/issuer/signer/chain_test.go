@@ -0,0 +1,200 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestIssuedCertificate returns a certificate signed by the issuer and its key
+func newTestIssuedCertificate(t *testing.T, commonName string, isCA bool, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey, issuerURLs ...string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IssuingCertificateURL: issuerURLs,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func encodeTestCertificates(certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+func TestNewSignedCertificate(t *testing.T) {
+	root, rootKey := newTestCA(t, "root")
+	intermediate, intermediateKey := newTestIssuedCertificate(t, "intermediate", true, root, rootKey)
+	leaf, _ := newTestIssuedCertificate(t, "leaf", false, intermediate, intermediateKey)
+	otherRoot, _ := newTestCA(t, "root")
+
+	tests := []struct {
+		name            string
+		leaf            *x509.Certificate
+		certs           []*x509.Certificate
+		wantCertificate []*x509.Certificate
+		wantCA          *x509.Certificate
+	}{
+		{
+			name:            "chain in reverse order",
+			leaf:            leaf,
+			certs:           []*x509.Certificate{root, intermediate, leaf},
+			wantCertificate: []*x509.Certificate{leaf, intermediate},
+			wantCA:          root,
+		},
+		{
+			name:            "unrelated certificates are skipped",
+			leaf:            leaf,
+			certs:           []*x509.Certificate{otherRoot, intermediate, root},
+			wantCertificate: []*x509.Certificate{leaf, intermediate},
+			wantCA:          root,
+		},
+		{
+			name:            "unknown root",
+			leaf:            leaf,
+			certs:           []*x509.Certificate{intermediate},
+			wantCertificate: []*x509.Certificate{leaf, intermediate},
+			wantCA:          intermediate,
+		},
+		{
+			name:            "no issuer",
+			leaf:            leaf,
+			wantCertificate: []*x509.Certificate{leaf},
+			wantCA:          leaf,
+		},
+		{
+			name:            "self-signed certificate",
+			leaf:            root,
+			certs:           []*x509.Certificate{root},
+			wantCertificate: []*x509.Certificate{root},
+			wantCA:          root,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed := newSignedCertificate(tt.leaf, tt.certs)
+			if want := encodeTestCertificates(tt.wantCertificate...); !bytes.Equal(signed.Certificate, want) {
+				t.Errorf("Certificate = %s, want %s", signed.Certificate, want)
+			}
+			if want := encodeTestCertificates(tt.wantCA); !bytes.Equal(signed.CA, want) {
+				t.Errorf("CA = %s, want %s", signed.CA, want)
+			}
+		})
+	}
+}
+
+func TestDownloadIssuer(t *testing.T) {
+	root, rootKey := newTestCA(t, "root")
+	intermediate, intermediateKey := newTestIssuedCertificate(t, "intermediate", true, root, rootKey)
+	otherRoot, _ := newTestCA(t, "other-root")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/intermediate.cer":
+			_, _ = w.Write(intermediate.Raw)
+		case "/intermediate.pem":
+			_, _ = w.Write(encodeTestCertificates(intermediate))
+		case "/other.cer":
+			_, _ = w.Write(otherRoot.Raw)
+		case "/oversized.pem":
+			_, _ = w.Write(encodeTestCertificates(intermediate))
+			_, _ = w.Write(bytes.Repeat([]byte("\n"), maxIssuerCertificateSize))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		issuerURLs []string
+		want       *x509.Certificate
+	}{
+		{
+			name:       "DER",
+			issuerURLs: []string{server.URL + "/intermediate.cer"},
+			want:       intermediate,
+		},
+		{
+			name:       "PEM",
+			issuerURLs: []string{server.URL + "/intermediate.pem"},
+			want:       intermediate,
+		},
+		{
+			name:       "skips missing and wrong issuers",
+			issuerURLs: []string{server.URL + "/missing.cer", server.URL + "/other.cer", server.URL + "/intermediate.cer"},
+			want:       intermediate,
+		},
+		{
+			name:       "oversized response",
+			issuerURLs: []string{server.URL + "/oversized.pem"},
+		},
+		{
+			name:       "issuer not found",
+			issuerURLs: []string{server.URL + "/other.cer"},
+		},
+		{
+			name: "no AIA URLs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf, _ := newTestIssuedCertificate(t, "leaf", false, intermediate, intermediateKey, tt.issuerURLs...)
+			got := downloadIssuer(context.Background(), leaf)
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("downloadIssuer() = %s, want nil", got.Subject)
+			case tt.want != nil && (got == nil || !got.Equal(tt.want)):
+				t.Errorf("downloadIssuer() = %v, want %s", got, tt.want.Subject)
+			}
+		})
+	}
+}
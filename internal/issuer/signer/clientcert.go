@@ -0,0 +1,104 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// parseClientCertificate returns the certificate and RSA private key of the
+// service principal from PEM or PFX content. The password decrypts the PFX or
+// an encrypted PEM private key.
+func parseClientCertificate(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var blocks []*pem.Block
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		for rest := data; ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			blocks = append(blocks, block)
+		}
+	} else {
+		var err error
+		if blocks, err = pkcs12.ToPEM(data, password); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse PFX client certificate: %v", err)
+		}
+	}
+
+	var certs []*x509.Certificate
+	var key *rsa.PrivateKey
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse client certificate: %v", err)
+			}
+			certs = append(certs, cert)
+		case "PRIVATE KEY", "RSA PRIVATE KEY":
+			k, err := parseRSAPrivateKey(block, password)
+			if err != nil {
+				return nil, nil, err
+			}
+			key = k
+		}
+	}
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("client certificate not found")
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("client certificate private key not found")
+	}
+	// the other certificates are the issuing chain of the client certificate,
+	// in no particular order in PFX files
+	for _, cert := range certs {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok && pub.N.Cmp(key.N) == 0 && pub.E == key.E {
+			return cert, key, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no client certificate matches the private key")
+}
+
+func parseRSAPrivateKey(block *pem.Block, password string) (*rsa.PrivateKey, error) {
+	der := block.Bytes
+	// legacy encrypted PEM keys, as produced by openssl rsa -aes256
+	if x509.IsEncryptedPEMBlock(block) {
+		var err error
+		if der, err = x509.DecryptPEMBlock(block, []byte(password)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt client certificate private key: %v", err)
+		}
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate private key: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("client certificate private key must be an RSA key, got %T", key)
+	}
+	return rsaKey, nil
+}
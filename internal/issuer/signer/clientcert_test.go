@@ -0,0 +1,144 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestClientCertificate returns the PEM encoded CA certificate, client
+// certificate and client key of a service principal
+func newTestClientCertificate(t *testing.T) ([]byte, []byte, []byte) {
+	t.Helper()
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, caTemplate, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestParseClientCertificatePEM(t *testing.T) {
+	caPEM, certPEM, keyPEM := newTestClientCertificate(t)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{
+			name: "certificate first",
+			data: bytes.Join([][]byte{certPEM, caPEM, keyPEM}, nil),
+		},
+		{
+			name: "chain first",
+			data: bytes.Join([][]byte{caPEM, certPEM, keyPEM}, nil),
+		},
+		{
+			name: "key first",
+			data: bytes.Join([][]byte{keyPEM, caPEM, certPEM}, nil),
+		},
+		{
+			name:    "no certificate of the key",
+			data:    bytes.Join([][]byte{caPEM, keyPEM}, nil),
+			wantErr: true,
+		},
+		{
+			name:    "no key",
+			data:    bytes.Join([][]byte{certPEM, caPEM}, nil),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, key, err := parseClientCertificate(tt.data, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClientCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cert.Subject.CommonName != "client" {
+				t.Errorf("certificate = %s, want CN=client", cert.Subject)
+			}
+			if !key.PublicKey.Equal(cert.PublicKey) {
+				t.Error("certificate doesn't match the private key")
+			}
+		})
+	}
+}
+
+func TestParseClientCertificatePFX(t *testing.T) {
+	// the PFX lists the CA certificate before the client certificate, it was
+	// created with openssl pkcs12 -export -certpbe NONE and the certificate
+	// bags swapped afterwards
+	pfx, err := ioutil.ReadFile(filepath.Join("testdata", "client-chain-first.pfx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, key, err := parseClientCertificate(pfx, "password")
+	if err != nil {
+		t.Fatalf("parseClientCertificate() error = %v", err)
+	}
+	if cert.Subject.CommonName != "client" {
+		t.Errorf("certificate = %s, want CN=client", cert.Subject)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		t.Error("certificate doesn't match the private key")
+	}
+
+	if _, _, err := parseClientCertificate(pfx, "wrong"); err == nil {
+		t.Error("parseClientCertificate() succeeded with a wrong password")
+	}
+}
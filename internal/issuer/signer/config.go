@@ -17,7 +17,10 @@ limitations under the License.
 package signer
 
 import (
+	"crypto/rsa"
+	"crypto/x509"
 	"fmt"
+	"os"
 	"strconv"
 )
 
@@ -26,8 +29,15 @@ const (
 	tenantIDKey               = "tenantID"
 	aadClientIDKey            = "aadClientID"
 	aadClientSecretKey        = "aadClientSecret"
+	aadClientCertificateKey   = "aadClientCertificate"
+	aadClientCertPasswordKey  = "aadClientCertificatePassword"
 	useManagedIdentityKey     = "useManagedIdentity"
 	userAssignedIdentityIDKey = "userAssignedIdentity"
+	userAssignedObjectIDKey   = "userAssignedIdentityObjectID"
+	userAssignedResourceIDKey = "userAssignedIdentityResourceID"
+	msiEndpointKey            = "msiEndpoint"
+	useWorkloadIdentityKey    = "useWorkloadIdentity"
+	federatedTokenFileKey     = "federatedTokenFile"
 )
 
 // authConfig holds auth related part of cloud config
@@ -40,12 +50,45 @@ type authConfig struct {
 	aadClientID string
 	// The ClientSecret for an AAD application with RBAC access to keyvault
 	aadClientSecret string
+	// The certificate and private key of an AAD application with RBAC access
+	// to keyvault, parsed from PEM or PFX content
+	aadClientCertificate *x509.Certificate
+	aadClientKey         *rsa.PrivateKey
 	// Use managed service identity to access keyvault instance
 	useManagedIdentity bool
 	// UserAssignedIdentityID contains the Client ID of the user assigned MSI which is assigned to the underlying VMs. If empty the user assigned identity is not used.
 	// More details of the user assigned identity can be found at: https://docs.microsoft.com/en-us/azure/active-directory/managed-service-identity/overview
 	// For the user assigned identity specified here to be used, the UseManagedIdentityExtension has to be set to true.
 	userAssignedIdentityID string
+	// The object ID of the user assigned MSI, an alternative to the client ID
+	userAssignedIdentityObjectID string
+	// The Azure resource ID of the user assigned MSI, an alternative to the client ID
+	userAssignedIdentityResourceID string
+	// The token endpoint of the instance metadata service, overrides the default
+	// IMDS endpoint, e.g. when the identity is provided by a proxy
+	msiEndpoint string
+	// Use Azure AD Workload Identity to exchange the federated service account
+	// token for an AAD token of the aadClientID application
+	useWorkloadIdentity bool
+	// The path of the federated service account token
+	federatedTokenFile string
+}
+
+// authMethod names the authentication method of the configuration, in the order
+// the methods are tried by getServicePrincipalToken
+func (c *authConfig) authMethod() string {
+	switch {
+	case c.useWorkloadIdentity:
+		return "workload_identity"
+	case c.useManagedIdentity:
+		return "managed_identity"
+	case c.aadClientSecret != "":
+		return "client_secret"
+	case c.aadClientCertificate != nil:
+		return "client_certificate"
+	default:
+		return "none"
+	}
 }
 
 // getConfigFromSecretData returns authConfig based on the credentials provided in the secret data
@@ -58,6 +101,9 @@ func getConfigFromSecretData(data map[string][]byte) (*authConfig, error) {
 	config.aadClientID = string(data[aadClientIDKey])
 	config.aadClientSecret = string(data[aadClientSecretKey])
 	config.userAssignedIdentityID = string(data[userAssignedIdentityIDKey])
+	config.userAssignedIdentityObjectID = string(data[userAssignedObjectIDKey])
+	config.userAssignedIdentityResourceID = string(data[userAssignedResourceIDKey])
+	config.msiEndpoint = string(data[msiEndpointKey])
 
 	config.useManagedIdentity = false
 	if string(data[useManagedIdentityKey]) != "" {
@@ -66,5 +112,42 @@ func getConfigFromSecretData(data map[string][]byte) (*authConfig, error) {
 		}
 	}
 
+	identities := 0
+	for _, id := range []string{config.userAssignedIdentityID, config.userAssignedIdentityObjectID, config.userAssignedIdentityResourceID} {
+		if id != "" {
+			identities++
+		}
+	}
+	if identities > 1 {
+		return nil, fmt.Errorf("failed to parse auth config: only one of %s, %s and %s can be set", userAssignedIdentityIDKey, userAssignedObjectIDKey, userAssignedResourceIDKey)
+	}
+
+	if len(data[aadClientCertificateKey]) > 0 {
+		config.aadClientCertificate, config.aadClientKey, err = parseClientCertificate(data[aadClientCertificateKey], string(data[aadClientCertPasswordKey]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse auth config: %v", err)
+		}
+	}
+
+	if string(data[useWorkloadIdentityKey]) != "" {
+		if config.useWorkloadIdentity, err = strconv.ParseBool(string(data[useWorkloadIdentityKey])); err != nil {
+			return nil, fmt.Errorf("failed to parse auth config: %+v", err)
+		}
+	}
+	if config.useWorkloadIdentity {
+		// the workload identity webhook injects the client, tenant and token
+		// file into the controller pod, the Secret takes precedence
+		config.federatedTokenFile = string(data[federatedTokenFileKey])
+		if config.federatedTokenFile == "" {
+			config.federatedTokenFile = os.Getenv(federatedTokenFileEnv)
+		}
+		if config.aadClientID == "" {
+			config.aadClientID = os.Getenv(clientIDEnv)
+		}
+		if config.tenantID == "" {
+			config.tenantID = os.Getenv(tenantIDEnv)
+		}
+	}
+
 	return config, nil
 }
@@ -0,0 +1,129 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// Kinds of failed keyvault requests, matched with errors.Is
+var (
+	// ErrUnauthorized is returned when no token could be acquired or keyvault
+	// rejected the token. It is usually resolved by fixing the credentials.
+	ErrUnauthorized = errors.New("keyvault request is unauthorized")
+	// ErrForbidden is returned when the identity lacks access to the vault
+	ErrForbidden = errors.New("keyvault request is forbidden")
+	// ErrNotFound is returned when the keyvault object doesn't exist
+	ErrNotFound = errors.New("keyvault object not found")
+	// ErrThrottled is returned when keyvault throttled the request
+	ErrThrottled = errors.New("keyvault request was throttled")
+	// ErrInvalidRequest is returned when keyvault rejected the request or the
+	// certificate request is malformed
+	ErrInvalidRequest = errors.New("keyvault request is invalid")
+	// ErrPolicyViolation is returned when the request is denied by a policy
+	// of the vault
+	ErrPolicyViolation = errors.New("keyvault request violates a policy")
+)
+
+// Error is a failed keyvault request classified by one of the error kinds
+type Error struct {
+	// Kind is the kind of failure, one of the Err variables of this package
+	Kind error
+	// StatusCode is the HTTP status code of the response, zero if there is none
+	StatusCode int
+	// Code is the error code reported by keyvault
+	Code string
+	// RetryAfter is the delay requested by keyvault before retrying a
+	// throttled request, zero if not set
+	RetryAfter time.Duration
+	// Err is the error returned by the keyvault client
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Is matches the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent returns true if signing the same request again can't succeed, so
+// the CertificateRequest should be failed rather than retried
+func IsPermanent(err error) bool {
+	for _, kind := range []error{ErrOperationFailed, ErrUnsupportedRequest, ErrInvalidRequest, ErrPolicyViolation, ErrForbidden, ErrNotFound} {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyvaultError classifies an error returned by the keyvault client. Errors
+// that don't match a kind, such as server errors and network failures, are
+// returned unchanged and are retried.
+func keyvaultError(err error) error {
+	var detailedErr autorest.DetailedError
+	if !errors.As(err, &detailedErr) {
+		return err
+	}
+	// requests held back by the rate limiter of the vault are never sent
+	if kvErr, ok := detailedErr.Original.(*Error); ok {
+		return kvErr
+	}
+	// the authorizer fails when no token can be acquired
+	if detailedErr.PackageType == "azure.BearerAuthorizer" {
+		return &Error{Kind: ErrUnauthorized, Err: err}
+	}
+
+	statusCode, _ := detailedErr.StatusCode.(int)
+	var code, innerCode string
+	if reqErr, ok := detailedErr.Original.(*azure.RequestError); ok && reqErr.ServiceError != nil {
+		code = reqErr.ServiceError.Code
+		innerCode, _ = reqErr.ServiceError.InnerError["code"].(string)
+	}
+	e := &Error{StatusCode: statusCode, Code: code, Err: err}
+	switch {
+	case strings.Contains(code, "Policy") || strings.Contains(innerCode, "Policy"):
+		e.Kind = ErrPolicyViolation
+	case statusCode == http.StatusUnauthorized:
+		e.Kind = ErrUnauthorized
+	case statusCode == http.StatusForbidden:
+		e.Kind = ErrForbidden
+	case statusCode == http.StatusNotFound:
+		e.Kind = ErrNotFound
+	case statusCode == http.StatusTooManyRequests:
+		e.Kind = ErrThrottled
+		e.RetryAfter = retryAfter(detailedErr.Response)
+	case statusCode == http.StatusBadRequest:
+		e.Kind = ErrInvalidRequest
+	default:
+		return err
+	}
+	return e
+}
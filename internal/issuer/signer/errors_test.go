@@ -0,0 +1,204 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+	"github.com/aramase/azure-external-issuer/internal/testutil/fakekeyvault"
+)
+
+func TestKeyVaultErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		setup         func(*fakekeyvault.Server, *fakekeyvault.Vault)
+		wantCheckErr  error
+		wantSignErr   error
+		wantPermanent bool
+	}{
+		{
+			name:          "issuer not found",
+			setup:         func(*fakekeyvault.Server, *fakekeyvault.Vault) {},
+			wantCheckErr:  ErrNotFound,
+			wantPermanent: true,
+		},
+		{
+			name: "token request rejected",
+			setup: func(s *fakekeyvault.Server, v *fakekeyvault.Vault) {
+				v.AddIssuer("issuer")
+				s.Inject(fakekeyvault.Fault{Path: "/*/oauth2/token", StatusCode: http.StatusUnauthorized})
+			},
+			wantCheckErr: ErrUnauthorized,
+		},
+		{
+			name: "forbidden by policy",
+			setup: func(s *fakekeyvault.Server, v *fakekeyvault.Vault) {
+				v.AddIssuer("issuer")
+				s.Inject(fakekeyvault.Fault{Path: "/certificates/*/create", StatusCode: http.StatusForbidden, InnerCode: "ForbiddenByPolicy"})
+			},
+			wantSignErr:   ErrPolicyViolation,
+			wantPermanent: true,
+		},
+		{
+			name: "forbidden by access policy",
+			setup: func(s *fakekeyvault.Server, v *fakekeyvault.Vault) {
+				v.AddIssuer("issuer")
+				s.Inject(fakekeyvault.Fault{Path: "/certificates/*/create", StatusCode: http.StatusForbidden})
+			},
+			wantSignErr:   ErrForbidden,
+			wantPermanent: true,
+		},
+		{
+			name: "invalid request",
+			setup: func(s *fakekeyvault.Server, v *fakekeyvault.Vault) {
+				v.AddIssuer("issuer")
+				s.Inject(fakekeyvault.Fault{Path: "/certificates/*/create", StatusCode: http.StatusBadRequest, InnerCode: "BadParameter"})
+			},
+			wantSignErr:   ErrInvalidRequest,
+			wantPermanent: true,
+		},
+		{
+			name: "operation failed",
+			setup: func(s *fakekeyvault.Server, v *fakekeyvault.Vault) {
+				v.AddIssuer("issuer")
+				v.SetOperationFailure("issuer unavailable")
+			},
+			wantSignErr:   ErrOperationFailed,
+			wantPermanent: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			vault := server.AddVault("kv-errors")
+			tt.setup(server, vault)
+			spec := v1alpha1.IssuerSpec{KeyvaultName: "kv-errors", IssuerName: "issuer"}
+			s, err := NewSigner(testCreds, spec)
+			if err != nil {
+				t.Fatalf("NewSigner() error = %v", err)
+			}
+
+			err = s.CheckIssuer(context.Background(), spec)
+			if tt.wantCheckErr != nil {
+				if !errors.Is(err, tt.wantCheckErr) {
+					t.Fatalf("CheckIssuer() error = %v, want %v", err, tt.wantCheckErr)
+				}
+				if got := IsPermanent(err); got != tt.wantPermanent {
+					t.Errorf("IsPermanent() = %v, want %v", got, tt.wantPermanent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckIssuer() error = %v", err)
+			}
+
+			req, _ := newTestRequest(t, "default-cert", "example.com")
+			_, err = s.Sign(context.Background(), req, spec)
+			var pending *PendingError
+			if errors.As(err, &pending) {
+				req.OperationID = pending.OperationID
+				_, err = s.Sign(context.Background(), req, spec)
+			}
+			if !errors.Is(err, tt.wantSignErr) {
+				t.Fatalf("Sign() error = %v, want %v", err, tt.wantSignErr)
+			}
+			if got := IsPermanent(err); got != tt.wantPermanent {
+				t.Errorf("IsPermanent() = %v, want %v", got, tt.wantPermanent)
+			}
+		})
+	}
+}
+
+// newKeySignerSpec adds the CA key to the vault and returns the spec of an
+// issuer of the backend signing with it
+func newKeySignerSpec(vault *fakekeyvault.Vault, vaultName, backend string) v1alpha1.IssuerSpec {
+	caCert := vault.AddCACertificate("ca")
+	if backend == v1alpha1.BackendManagedHSM {
+		return v1alpha1.IssuerSpec{
+			KeyvaultName: vaultName,
+			Backend:      v1alpha1.BackendManagedHSM,
+			ManagedHSM: &v1alpha1.ManagedHSMSpec{
+				KeyName:       "ca",
+				CACertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})),
+			},
+		}
+	}
+	return v1alpha1.IssuerSpec{KeyvaultName: vaultName, CACertificateName: "ca"}
+}
+
+func TestKeySignErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		fault         fakekeyvault.Fault
+		wantErr       error
+		wantPermanent bool
+	}{
+		{
+			name:          "forbidden",
+			fault:         fakekeyvault.Fault{StatusCode: http.StatusForbidden},
+			wantErr:       ErrForbidden,
+			wantPermanent: true,
+		},
+		{
+			name:    "unauthorized",
+			fault:   fakekeyvault.Fault{StatusCode: http.StatusUnauthorized},
+			wantErr: ErrUnauthorized,
+		},
+		{
+			name:    "throttled",
+			fault:   fakekeyvault.Fault{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second},
+			wantErr: ErrThrottled,
+		},
+	}
+	backends := map[string]string{v1alpha1.BackendKeyVaultCA: "kv-errors", v1alpha1.BackendManagedHSM: "hsm-errors"}
+	for backend, prefix := range backends {
+		for _, tt := range tests {
+			t.Run(backend+" "+tt.name, func(t *testing.T) {
+				server := newTestServer(t)
+				// throttling blocks the vault for all signers, so each case
+				// uses its own vault
+				vaultName := prefix + "-" + tt.name
+				spec := newKeySignerSpec(server.AddVault(vaultName), vaultName, backend)
+				s, err := NewSigner(testCreds, spec)
+				if err != nil {
+					t.Fatalf("NewSigner() error = %v", err)
+				}
+				if err := s.CheckIssuer(context.Background(), spec); err != nil {
+					t.Fatalf("CheckIssuer() error = %v", err)
+				}
+
+				fault := tt.fault
+				fault.Method, fault.Path, fault.Times = http.MethodPost, "/keys/*/*/sign", 1
+				server.Inject(fault)
+				req, _ := newTestRequest(t, "default-cert", "example.com")
+				_, err = s.Sign(context.Background(), req, spec)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Sign() error = %v, want %v", err, tt.wantErr)
+				}
+				if got := IsPermanent(err); got != tt.wantPermanent {
+					t.Errorf("IsPermanent() = %v, want %v", got, tt.wantPermanent)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+const (
+	// environment variables set by the Azure AD Workload Identity webhook
+	federatedTokenFileEnv = "AZURE_FEDERATED_TOKEN_FILE"
+	clientIDEnv           = "AZURE_CLIENT_ID"
+	tenantIDEnv           = "AZURE_TENANT_ID"
+	authorityHostEnv      = "AZURE_AUTHORITY_HOST"
+
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// federatedTokenSecret authenticates the service principal with a client
+// assertion read from a projected service account token. The file is read on
+// every token refresh, as the kubelet rotates the token.
+type federatedTokenSecret struct {
+	tokenFile string
+}
+
+// SetAuthenticationValues is a method of the interface ServicePrincipalSecret
+// It will populate the form submitted during oAuth Token Acquisition using the
+// federated token as client assertion.
+func (s *federatedTokenSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	token, err := ioutil.ReadFile(s.tokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read federated token file %s: %v", s.tokenFile, err)
+	}
+	v.Set("client_assertion_type", clientAssertionType)
+	v.Set("client_assertion", strings.TrimSpace(string(token)))
+	return nil
+}
+
+// getFederatedToken returns a service principal token that exchanges the
+// federated token for an AAD token
+func getFederatedToken(config *authConfig, activeDirectoryEndpoint, resource string) (adal.OAuthTokenProvider, error) {
+	if config.aadClientID == "" || config.tenantID == "" || config.federatedTokenFile == "" {
+		return nil, fmt.Errorf("workload identity requires the client ID, tenant ID and federated token file")
+	}
+	oauthConfig, err := adal.NewOAuthConfig(activeDirectoryEndpoint, config.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OAuth config, error: %v", err)
+	}
+	return adal.NewServicePrincipalTokenWithSecret(*oauthConfig, config.aadClientID, resource, &federatedTokenSecret{
+		tokenFile: config.federatedTokenFile,
+	})
+}
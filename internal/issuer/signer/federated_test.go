@@ -0,0 +1,203 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+
+	"github.com/aramase/azure-external-issuer/internal/testutil/fakekeyvault"
+)
+
+// writeTokenFile writes the federated token to the file
+func writeTokenFile(t *testing.T, tokenFile, token string) {
+	t.Helper()
+	if err := ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setEnv sets the environment variable for the duration of the test
+func setEnv(t *testing.T, key, value string) {
+	t.Helper()
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// newFederatedToken returns the service principal token of the workload
+// identity credentials, sending its token requests to the fake AAD
+func newFederatedToken(t *testing.T, data map[string][]byte) *adal.ServicePrincipalToken {
+	t.Helper()
+	config, err := getConfigFromSecretData(data)
+	if err != nil {
+		t.Fatalf("getConfigFromSecretData() error = %v", err)
+	}
+	provider, err := getServicePrincipalToken(config, &azure.PublicCloud, "https://vault.azure.net")
+	if err != nil {
+		t.Fatalf("getServicePrincipalToken() error = %v", err)
+	}
+	setTokenSender(provider)
+	spt, ok := provider.(*adal.ServicePrincipalToken)
+	if !ok {
+		t.Fatalf("getServicePrincipalToken() returned %T, want a service principal token", provider)
+	}
+	return spt
+}
+
+func TestFederatedToken(t *testing.T) {
+	server := newTestServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeTokenFile(t, tokenFile, "first-token")
+
+	spt := newFederatedToken(t, map[string][]byte{
+		useWorkloadIdentityKey: []byte("true"),
+		tenantIDKey:            []byte("tenant"),
+		aadClientIDKey:         []byte("client-id"),
+		federatedTokenFileKey:  []byte(tokenFile),
+	})
+	if err := spt.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got := spt.OAuthToken(); got != fakekeyvault.AccessToken {
+		t.Errorf("OAuthToken() = %q, want %q", got, fakekeyvault.AccessToken)
+	}
+
+	// the kubelet rotates the token, it is read again on every refresh
+	writeTokenFile(t, tokenFile, "second-token")
+	if err := spt.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	forms := server.TokenForms()
+	if len(forms) != 2 {
+		t.Fatalf("got %d token requests, want 2", len(forms))
+	}
+	for i, wantAssertion := range []string{"first-token", "second-token"} {
+		form := forms[i]
+		for key, want := range map[string]string{
+			"grant_type":            "client_credentials",
+			"client_id":             "client-id",
+			"resource":              "https://vault.azure.net",
+			"client_assertion_type": clientAssertionType,
+			"client_assertion":      wantAssertion,
+		} {
+			if got := form.Get(key); got != want {
+				t.Errorf("token request %d: %s = %q, want %q", i, key, got, want)
+			}
+		}
+		if form.Get("client_secret") != "" {
+			t.Errorf("token request %d: unexpected client_secret", i)
+		}
+	}
+	if got := countRequests(server, "POST /tenant/oauth2/token"); got != 2 {
+		t.Errorf("got %d token requests for the tenant, want 2", got)
+	}
+}
+
+func TestFederatedTokenEnvironment(t *testing.T) {
+	envTokenFile := filepath.Join(t.TempDir(), "env-token")
+	writeTokenFile(t, envTokenFile, "env-token")
+	secretTokenFile := filepath.Join(t.TempDir(), "secret-token")
+	writeTokenFile(t, secretTokenFile, "secret-token")
+
+	tests := []struct {
+		name          string
+		data          map[string][]byte
+		wantTenant    string
+		wantClientID  string
+		wantAssertion string
+	}{
+		{
+			name:          "environment injected by the webhook",
+			wantTenant:    "env-tenant",
+			wantClientID:  "env-client-id",
+			wantAssertion: "env-token",
+		},
+		{
+			name: "secret takes precedence",
+			data: map[string][]byte{
+				tenantIDKey:           []byte("secret-tenant"),
+				aadClientIDKey:        []byte("secret-client-id"),
+				federatedTokenFileKey: []byte(secretTokenFile),
+			},
+			wantTenant:    "secret-tenant",
+			wantClientID:  "secret-client-id",
+			wantAssertion: "secret-token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			setEnv(t, tenantIDEnv, "env-tenant")
+			setEnv(t, clientIDEnv, "env-client-id")
+			setEnv(t, federatedTokenFileEnv, envTokenFile)
+
+			data := map[string][]byte{useWorkloadIdentityKey: []byte("true")}
+			for k, v := range tt.data {
+				data[k] = v
+			}
+			spt := newFederatedToken(t, data)
+			if err := spt.Refresh(); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+
+			forms := server.TokenForms()
+			if len(forms) != 1 {
+				t.Fatalf("got %d token requests, want 1", len(forms))
+			}
+			if got := forms[0].Get("client_id"); got != tt.wantClientID {
+				t.Errorf("client_id = %q, want %q", got, tt.wantClientID)
+			}
+			if got := forms[0].Get("client_assertion"); got != tt.wantAssertion {
+				t.Errorf("client_assertion = %q, want %q", got, tt.wantAssertion)
+			}
+			if got := countRequests(server, "POST /"+tt.wantTenant+"/oauth2/token"); got != 1 {
+				t.Errorf("got %d token requests for tenant %s, want 1", got, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestFederatedTokenMissingConfig(t *testing.T) {
+	for _, env := range []string{tenantIDEnv, clientIDEnv, federatedTokenFileEnv} {
+		setEnv(t, env, "")
+	}
+	config, err := getConfigFromSecretData(map[string][]byte{
+		useWorkloadIdentityKey: []byte("true"),
+		aadClientIDKey:         []byte("client-id"),
+	})
+	if err != nil {
+		t.Fatalf("getConfigFromSecretData() error = %v", err)
+	}
+	if _, err := getServicePrincipalToken(config, &azure.PublicCloud, "https://vault.azure.net"); err == nil {
+		t.Errorf("getServicePrincipalToken() expected an error without a tenant ID and token file")
+	}
+}
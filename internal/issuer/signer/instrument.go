@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+
+	"github.com/aramase/azure-external-issuer/internal/metrics"
+)
+
+// withMetrics is a SendDecorator that records the operation, status code and
+// latency of every keyvault request
+func withMetrics() autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			start := time.Now()
+			resp, err := s.Do(r)
+			code := 0
+			if resp != nil {
+				code = resp.StatusCode
+			}
+			metrics.ObserveKeyvaultRequest(keyvaultOperation(r.Method, r.URL.Path), code, time.Since(start))
+			return resp, err
+		})
+	}
+}
+
+// keyvaultOperation names the keyvault operation of a request after the methods
+// of the keyvault client, without the object names of the path
+func keyvaultOperation(method, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	collection := segments[0]
+	switch {
+	case collection == "certificates" && len(segments) == 1:
+		return "GetCertificates"
+	case collection == "certificates" && len(segments) == 3 && segments[1] == "issuers":
+		return "GetCertificateIssuer"
+	case collection == "certificates" && len(segments) == 3 && segments[2] == "create":
+		return "CreateCertificate"
+	case collection == "certificates" && len(segments) == 3 && segments[2] == "pending":
+		return "GetCertificateOperation"
+	case collection == "certificates" && method == http.MethodGet:
+		return "GetCertificate"
+	case collection == "secrets" && method == http.MethodGet:
+		return "GetSecret"
+	case collection == "keys" && len(segments) == 4 && segments[3] == "sign":
+		return "Sign"
+	case collection == "keys" && method == http.MethodGet:
+		return "GetKey"
+	default:
+		return method + " " + collection
+	}
+}
+
+// instrumentedAuthorizer counts the failures to acquire a token
+type instrumentedAuthorizer struct {
+	autorest.Authorizer
+	method string
+}
+
+// WithAuthorization returns a PrepareDecorator that authorizes the request with
+// the wrapped Authorizer and records its failures
+func (a *instrumentedAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	authorize := a.Authorizer.WithAuthorization()
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			r, err = autorest.Prepare(r, authorize)
+			if err != nil {
+				metrics.TokenAcquisitionFailed(a.method)
+			}
+			return r, err
+		})
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// keyProperties returns the keyvault key properties for a certificate created for
+// the CSR. The key type, size and curve default to the ones of the CSR public key;
+// if they are set in the issuer they must agree with the CSR.
+func keyProperties(csr *x509.CertificateRequest, issuerSpec v1alpha1.IssuerSpec) (*kv.KeyProperties, error) {
+	props := &kv.KeyProperties{}
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		props.KeyType = kv.RSA
+		props.KeySize = to.Int32Ptr(int32(pub.N.BitLen()))
+	case *ecdsa.PublicKey:
+		props.KeyType = kv.EC
+		props.Curve = kv.JSONWebKeyCurveName(pub.Curve.Params().Name)
+	default:
+		return nil, fmt.Errorf("%w: CSR public key type %T", ErrUnsupportedRequest, csr.PublicKey)
+	}
+
+	spec := issuerSpec.KeyProperties
+	if spec == nil {
+		return props, nil
+	}
+	if spec.KeyType != "" {
+		keyType := kv.JSONWebKeyType(spec.KeyType)
+		if keyFamily(keyType) != props.KeyType {
+			return nil, fmt.Errorf("%w: CSR key type %s doesn't match the issuer key type %s", ErrUnsupportedRequest, props.KeyType, keyType)
+		}
+		props.KeyType = keyType
+	}
+	if spec.KeySize != nil && props.KeySize != nil && *spec.KeySize != *props.KeySize {
+		return nil, fmt.Errorf("%w: CSR key size %d doesn't match the issuer key size %d", ErrUnsupportedRequest, *props.KeySize, *spec.KeySize)
+	}
+	if spec.Curve != "" && props.Curve != "" && kv.JSONWebKeyCurveName(spec.Curve) != props.Curve {
+		return nil, fmt.Errorf("%w: CSR curve %s doesn't match the issuer curve %s", ErrUnsupportedRequest, props.Curve, spec.Curve)
+	}
+	props.Exportable = spec.Exportable
+	props.ReuseKey = spec.ReuseKey
+	return props, nil
+}
+
+// keyFamily returns the software key type of a keyvault key type
+func keyFamily(keyType kv.JSONWebKeyType) kv.JSONWebKeyType {
+	switch keyType {
+	case kv.RSA, kv.RSAHSM:
+		return kv.RSA
+	case kv.EC, kv.ECHSM:
+		return kv.EC
+	default:
+		return keyType
+	}
+}
+
+// validateKeyProperties checks the key properties of the issuer are consistent
+func validateKeyProperties(issuerSpec v1alpha1.IssuerSpec) error {
+	return issuerSpec.KeyProperties.Validate(field.NewPath("keyProperties")).ToAggregate()
+}
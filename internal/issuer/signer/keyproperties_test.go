@@ -0,0 +1,184 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"reflect"
+	"testing"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// newTestCSRForKey returns a parsed CSR signed by the key
+func newTestCSRForKey(t *testing.T, key crypto.Signer) *x509.CertificateRequest {
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestKeyProperties(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCSR := newTestCSRForKey(t, rsaKey)
+	ecCSR := newTestCSRForKey(t, ecKey)
+
+	tests := []struct {
+		name    string
+		csr     *x509.CertificateRequest
+		spec    *v1alpha1.KeyProperties
+		want    *kv.KeyProperties
+		wantErr bool
+	}{
+		{
+			name: "RSA key of the CSR",
+			csr:  rsaCSR,
+			want: &kv.KeyProperties{KeyType: kv.RSA, KeySize: to.Int32Ptr(2048)},
+		},
+		{
+			name: "EC key of the CSR",
+			csr:  ecCSR,
+			want: &kv.KeyProperties{KeyType: kv.EC, Curve: kv.P384},
+		},
+		{
+			name: "HSM key type of the issuer",
+			csr:  rsaCSR,
+			spec: &v1alpha1.KeyProperties{KeyType: string(kv.RSAHSM), KeySize: to.Int32Ptr(2048)},
+			want: &kv.KeyProperties{KeyType: kv.RSAHSM, KeySize: to.Int32Ptr(2048)},
+		},
+		{
+			name: "EC HSM key type of the issuer",
+			csr:  ecCSR,
+			spec: &v1alpha1.KeyProperties{KeyType: string(kv.ECHSM), Curve: string(kv.P384)},
+			want: &kv.KeyProperties{KeyType: kv.ECHSM, Curve: kv.P384},
+		},
+		{
+			name: "exportable and reused keys",
+			csr:  ecCSR,
+			spec: &v1alpha1.KeyProperties{Exportable: to.BoolPtr(true), ReuseKey: to.BoolPtr(false)},
+			want: &kv.KeyProperties{KeyType: kv.EC, Curve: kv.P384, Exportable: to.BoolPtr(true), ReuseKey: to.BoolPtr(false)},
+		},
+		{
+			name:    "key type mismatch",
+			csr:     ecCSR,
+			spec:    &v1alpha1.KeyProperties{KeyType: string(kv.RSA)},
+			wantErr: true,
+		},
+		{
+			name:    "key size mismatch",
+			csr:     rsaCSR,
+			spec:    &v1alpha1.KeyProperties{KeySize: to.Int32Ptr(4096)},
+			wantErr: true,
+		},
+		{
+			name:    "curve mismatch",
+			csr:     ecCSR,
+			spec:    &v1alpha1.KeyProperties{Curve: string(kv.P256)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := keyProperties(tt.csr, v1alpha1.IssuerSpec{KeyProperties: tt.spec})
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedRequest) {
+					t.Fatalf("keyProperties() error = %v, want %v", err, ErrUnsupportedRequest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("keyProperties() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keyProperties() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateKeyProperties(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *v1alpha1.KeyProperties
+		wantErr bool
+	}{
+		{
+			name: "no key properties",
+		},
+		{
+			name: "RSA key size",
+			spec: &v1alpha1.KeyProperties{KeyType: string(kv.RSAHSM), KeySize: to.Int32Ptr(3072)},
+		},
+		{
+			name: "EC curve",
+			spec: &v1alpha1.KeyProperties{KeyType: string(kv.EC), Curve: string(kv.P256)},
+		},
+		{
+			name:    "curve of RSA key",
+			spec:    &v1alpha1.KeyProperties{KeyType: string(kv.RSA), Curve: string(kv.P256)},
+			wantErr: true,
+		},
+		{
+			name:    "key size of EC key",
+			spec:    &v1alpha1.KeyProperties{KeyType: string(kv.ECHSM), KeySize: to.Int32Ptr(2048)},
+			wantErr: true,
+		},
+		{
+			name:    "key size and curve",
+			spec:    &v1alpha1.KeyProperties{KeySize: to.Int32Ptr(2048), Curve: string(kv.P256)},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported key type",
+			spec:    &v1alpha1.KeyProperties{KeyType: "oct"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateKeyProperties(v1alpha1.IssuerSpec{KeyProperties: tt.spec})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateKeyProperties() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// keyClient signs digests with a key, implemented by the keyvault client and
+// the Managed HSM client
+type keyClient interface {
+	Sign(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, parameters kv.KeySignParameters) (kv.KeyOperationResult, error)
+}
+
+// keyvaultKey is a crypto.Signer backed by a key in keyvault. The private key
+// never leaves the vault, only the digest is sent to the sign operation.
+type keyvaultKey struct {
+	ctx        context.Context
+	baseClient keyClient
+	vaultURL   string
+	name       string
+	version    string
+	publicKey  crypto.PublicKey
+}
+
+var _ crypto.Signer = &keyvaultKey{}
+
+// Public returns the public key of the CA certificate associated with the key
+func (k *keyvaultKey) Public() crypto.PublicKey {
+	return k.publicKey
+}
+
+// Sign signs the digest using the keyvault sign operation
+func (k *keyvaultKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	algorithm, err := signatureAlgorithm(k.publicKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	params := kv.KeySignParameters{
+		Algorithm: algorithm,
+		Value:     to.StringPtr(base64.RawURLEncoding.EncodeToString(digest)),
+	}
+	result, err := k.baseClient.Sign(k.ctx, k.vaultURL, k.name, k.version, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign digest with key %s: %w", k.name, keyvaultError(err))
+	}
+	if result.Result == nil {
+		return nil, fmt.Errorf("empty signature returned for key %s", k.name)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(*result.Result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature for key %s: %v", k.name, err)
+	}
+	if _, ok := k.publicKey.(*ecdsa.PublicKey); ok {
+		// keyvault returns the raw r||s form, x509 expects an ASN.1 sequence
+		return ecdsaSignatureToASN1(signature)
+	}
+	return signature, nil
+}
+
+// signatureAlgorithm maps the key type and hash to the keyvault sign algorithm
+func signatureAlgorithm(publicKey crypto.PublicKey, opts crypto.SignerOpts) (kv.JSONWebKeySignatureAlgorithm, error) {
+	hash := opts.HashFunc()
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		_, pss := opts.(*rsa.PSSOptions)
+		switch {
+		case hash == crypto.SHA256 && pss:
+			return kv.PS256, nil
+		case hash == crypto.SHA384 && pss:
+			return kv.PS384, nil
+		case hash == crypto.SHA512 && pss:
+			return kv.PS512, nil
+		case hash == crypto.SHA256:
+			return kv.RS256, nil
+		case hash == crypto.SHA384:
+			return kv.RS384, nil
+		case hash == crypto.SHA512:
+			return kv.RS512, nil
+		}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return kv.ES256, nil
+		case crypto.SHA384:
+			return kv.ES384, nil
+		case crypto.SHA512:
+			return kv.ES512, nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported CA public key type %T", publicKey)
+	}
+	return "", fmt.Errorf("unsupported hash function %v for CA public key type %T", hash, publicKey)
+}
+
+// ecdsaSignatureToASN1 converts a raw r||s ECDSA signature to its ASN.1 form
+func ecdsaSignatureToASN1(signature []byte) ([]byte, error) {
+	if len(signature) == 0 || len(signature)%2 != 0 {
+		return nil, fmt.Errorf("invalid ECDSA signature length %d", len(signature))
+	}
+	half := len(signature) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(signature[:half]),
+		S: new(big.Int).SetBytes(signature[half:]),
+	})
+}
+
+// parseObjectID returns the name and version from a keyvault object identifier
+// of the form https://{vault}.vault.azure.net/{collection}/{name}/{version}
+func parseObjectID(id, collection string) (name, version string, err error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse object id %q: %v", id, err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != collection {
+		return "", "", fmt.Errorf("invalid %s id %q", collection, id)
+	}
+	name = parts[1]
+	if len(parts) > 2 {
+		version = parts[2]
+	}
+	return name, version, nil
+}
+
+// caKey is a CA certificate stored in keyvault along with a signer for the key
+// backing it
+type caKey struct {
+	cert     *x509.Certificate
+	signer   crypto.Signer
+	secretID string
+}
+
+// getCAKey returns the CA certificate stored in keyvault and its key
+func (s *caSigner) getCAKey(ctx context.Context, caCertificateName string) (*caKey, error) {
+	certBundle, err := s.baseClient.GetCertificate(ctx, s.vaultURL, caCertificateName, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CA certificate %s: %w", caCertificateName, keyvaultError(err))
+	}
+	if certBundle.Cer == nil || certBundle.Kid == nil {
+		return nil, fmt.Errorf("CA certificate %s has no certificate or key", caCertificateName)
+	}
+	caCert, err := x509.ParseCertificate(*certBundle.Cer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate %s: %v", caCertificateName, err)
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA certificate", caCertificateName)
+	}
+	keyName, keyVersion, err := parseObjectID(*certBundle.Kid, "keys")
+	if err != nil {
+		return nil, err
+	}
+	return &caKey{
+		cert: caCert,
+		signer: &keyvaultKey{
+			ctx:        ctx,
+			baseClient: s.baseClient,
+			vaultURL:   s.vaultURL,
+			name:       keyName,
+			version:    keyVersion,
+			publicKey:  caCert.PublicKey,
+		},
+		secretID: to.String(certBundle.Sid),
+	}, nil
+}
+
+// keyCASigner signs certificates with the key of a CA certificate in keyvault.
+// The certificate is built locally from the CSR and only the signature is
+// computed by keyvault, so the issued certificate carries the public key of the
+// CSR rather than a key generated by keyvault.
+type keyCASigner struct {
+	*caSigner
+}
+
+func newKeyCASigner(creds map[string][]byte, issuerSpec v1alpha1.IssuerSpec) (Signer, error) {
+	kvClient, vaultURL, err := newKeyvaultClient(creds, issuerSpec.KeyvaultName)
+	if err != nil {
+		return nil, err
+	}
+	return &keyCASigner{
+		caSigner: &caSigner{
+			baseClient: kvClient,
+			vaultURL:   vaultURL,
+		},
+	}, nil
+}
+
+// CheckIssuer validates the CA certificate and its key
+func (s *keyCASigner) CheckIssuer(ctx context.Context, issuerSpec v1alpha1.IssuerSpec) error {
+	if err := validateValidity(issuerSpec); err != nil {
+		return err
+	}
+	if issuerSpec.CACertificateName == "" {
+		return fmt.Errorf("caCertificateName is required by the %s backend", v1alpha1.BackendKeyVaultCA)
+	}
+	_, err := s.getCAKey(ctx, issuerSpec.CACertificateName)
+	return err
+}
+
+// Sign signs the certificate synchronously with the key of the CA certificate
+func (s *keyCASigner) Sign(ctx context.Context, req Request, issuerSpec v1alpha1.IssuerSpec) (*SignedCertificate, error) {
+	ca, err := s.getCAKey(ctx, issuerSpec.CACertificateName)
+	if err != nil {
+		return nil, err
+	}
+	return signWithCAKey(req, issuerSpec, ca, s.resolveChain(ctx, ca.cert, ca.secretID))
+}
+
+// signWithCAKey builds the certificate from the CSR and signs it with the CA key.
+// The chain holds the issuers of the CA certificate.
+func signWithCAKey(req Request, issuerSpec v1alpha1.IssuerSpec, ca *caKey, chain []*x509.Certificate) (*SignedCertificate, error) {
+	duration, err := certificateDuration(req.Duration, issuerSpec)
+	if err != nil {
+		return nil, err
+	}
+	keyUsage, extKeyUsage, err := pki.BuildKeyUsages(req.Usages, req.IsCA)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedRequest, err)
+	}
+	// the template generation also verifies the CSR signature
+	template, err := pki.GenerateTemplateFromCSRPEMWithUsages(req.CSR, duration, req.IsCA, keyUsage, extKeyUsage)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to generate certificate template from CSR: %v", ErrInvalidRequest, err)
+	}
+	// pki.SignCertificate flattens the error of the signer, create the
+	// certificate directly so the keyvault error kind is kept
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, template.PublicKey, ca.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate with CA %s: %w", ca.cert.Subject, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate signed by CA %s: %v", ca.cert.Subject, err)
+	}
+	return newSignedCertificate(cert, append([]*x509.Certificate{ca.cert}, chain...)), nil
+}
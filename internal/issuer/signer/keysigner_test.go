@@ -0,0 +1,53 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+func TestKeyVaultCASign(t *testing.T) {
+	server := newTestServer(t)
+	caCert := server.AddVault("kv-ca").AddCACertificate("ca")
+	spec := v1alpha1.IssuerSpec{KeyvaultName: "kv-ca", CACertificateName: "ca"}
+
+	s, err := NewSigner(testCreds, spec)
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+	if err := s.CheckIssuer(context.Background(), spec); err != nil {
+		t.Fatalf("CheckIssuer() error = %v", err)
+	}
+	req, key := newTestRequest(t, "default-cert", "example.com")
+	signed, err := s.Sign(context.Background(), req, spec)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	cert, ca := parseSignedCertificate(t, signed)
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("certificate is not signed by the CA: %v", err)
+	}
+	if !ca.Equal(caCert) {
+		t.Errorf("CA = %s, want %s", ca.Subject, caCert.Subject)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		t.Error("certificate doesn't carry the public key of the CSR")
+	}
+}
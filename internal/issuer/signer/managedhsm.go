@@ -0,0 +1,266 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/jetstack/cert-manager/pkg/util/pki"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// managedHSMAPIVersion is the keyvault API version of Managed HSM requests. The
+// keyvault client pins an older version that Managed HSM doesn't serve.
+const managedHSMAPIVersion = "7.2"
+
+// managedHSMDNSSuffixes are the Managed HSM DNS suffixes of the clouds that
+// offer Managed HSM, the token audience is the suffix as an https URL
+var managedHSMDNSSuffixes = map[string]string{
+	azure.PublicCloud.Name:       "managedhsm.azure.net",
+	azure.USGovernmentCloud.Name: "managedhsm.usgovcloudapi.net",
+	azure.ChinaCloud.Name:        "managedhsm.azure.cn",
+}
+
+func init() {
+	RegisterBackend(v1alpha1.BackendManagedHSM, newManagedHSMSigner)
+}
+
+// managedHSMClient calls the key operations of a Managed HSM
+type managedHSMClient struct {
+	autorest.Client
+}
+
+var _ keyClient = managedHSMClient{}
+
+// GetKey gets the public part of a key, the current version if keyVersion is empty
+func (c managedHSMClient) GetKey(ctx context.Context, hsmURL, keyName, keyVersion string) (result kv.KeyBundle, err error) {
+	path := "/keys/{key-name}"
+	pathParameters := map[string]interface{}{
+		"key-name": autorest.Encode("path", keyName),
+	}
+	if keyVersion != "" {
+		path += "/{key-version}"
+		pathParameters["key-version"] = autorest.Encode("path", keyVersion)
+	}
+	req, err := autorest.Prepare((&http.Request{}).WithContext(ctx),
+		autorest.AsGet(),
+		autorest.WithBaseURL(hsmURL),
+		autorest.WithPathParameters(path, pathParameters),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": managedHSMAPIVersion}))
+	if err != nil {
+		return result, autorest.NewErrorWithError(err, "signer.managedHSMClient", "GetKey", nil, "Failure preparing request")
+	}
+	err = c.do(req, "GetKey", &result)
+	return result, err
+}
+
+// Sign signs a digest with the key
+func (c managedHSMClient) Sign(ctx context.Context, hsmURL string, keyName string, keyVersion string, parameters kv.KeySignParameters) (result kv.KeyOperationResult, err error) {
+	req, err := autorest.Prepare((&http.Request{}).WithContext(ctx),
+		autorest.AsContentType("application/json; charset=utf-8"),
+		autorest.AsPost(),
+		autorest.WithBaseURL(hsmURL),
+		autorest.WithPathParameters("/keys/{key-name}/{key-version}/sign", map[string]interface{}{
+			"key-name":    autorest.Encode("path", keyName),
+			"key-version": autorest.Encode("path", keyVersion),
+		}),
+		autorest.WithJSON(parameters),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": managedHSMAPIVersion}))
+	if err != nil {
+		return result, autorest.NewErrorWithError(err, "signer.managedHSMClient", "Sign", nil, "Failure preparing request")
+	}
+	err = c.do(req, "Sign", &result)
+	return result, err
+}
+
+// do sends the request and unmarshals the response into result. Failed requests
+// return the same errors as the keyvault client, so they are classified alike.
+func (c managedHSMClient) do(req *http.Request, method string, result interface{}) error {
+	resp, err := c.Send(req, autorest.DoRetryForStatusCodes(c.RetryAttempts, c.RetryDuration, autorest.StatusCodesForRetry...))
+	if err != nil {
+		return autorest.NewErrorWithError(err, "signer.managedHSMClient", method, resp, "Failure sending request")
+	}
+	err = autorest.Respond(resp,
+		azure.WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByUnmarshallingJSON(result),
+		autorest.ByClosing())
+	if err != nil {
+		return autorest.NewErrorWithError(err, "signer.managedHSMClient", method, resp, "Failure responding to request")
+	}
+	return nil
+}
+
+// hsmSigner signs certificates with a key in a Managed HSM. Like the KeyVaultCA
+// backend, the certificate is built locally from the CSR and only the signature
+// is computed by the HSM.
+type hsmSigner struct {
+	client managedHSMClient
+	hsmURL string
+}
+
+func newManagedHSMSigner(creds map[string][]byte, issuerSpec v1alpha1.IssuerSpec) (Signer, error) {
+	config, err := getConfigFromSecretData(creds)
+	if err != nil {
+		return nil, err
+	}
+	env, err := parseCloudEnvironment(config.cloud)
+	if err != nil {
+		return nil, err
+	}
+	dnsSuffix, ok := managedHSMDNSSuffixes[env.Name]
+	if !ok {
+		return nil, fmt.Errorf("managed HSM is not available in cloud %s", env.Name)
+	}
+	hsmURL, err := getVaultURL(dnsSuffix, issuerSpec.KeyvaultName)
+	if err != nil {
+		return nil, err
+	}
+	client := autorest.NewClientWithUserAgent(kv.UserAgent())
+	if err := client.AddToUserAgent("cert-manager-issuer"); err != nil {
+		return nil, fmt.Errorf("failed to add user agent to managed HSM client, error: %+v", err)
+	}
+	if err := authorizeClient(&client, config, env, *hsmURL, "https://"+dnsSuffix); err != nil {
+		return nil, err
+	}
+	return &hsmSigner{
+		client: managedHSMClient{Client: client},
+		hsmURL: *hsmURL,
+	}, nil
+}
+
+// CheckIssuer validates the CA certificate and that the HSM key can sign for it
+func (s *hsmSigner) CheckIssuer(ctx context.Context, issuerSpec v1alpha1.IssuerSpec) error {
+	if err := validateValidity(issuerSpec); err != nil {
+		return err
+	}
+	_, _, err := s.getCAKey(ctx, issuerSpec.ManagedHSM)
+	return err
+}
+
+// Sign signs the certificate synchronously with the HSM key
+func (s *hsmSigner) Sign(ctx context.Context, req Request, issuerSpec v1alpha1.IssuerSpec) (*SignedCertificate, error) {
+	ca, chain, err := s.getCAKey(ctx, issuerSpec.ManagedHSM)
+	if err != nil {
+		return nil, err
+	}
+	return signWithCAKey(req, issuerSpec, ca, chain)
+}
+
+// getCAKey returns the CA certificate of the issuer along with a signer for the
+// HSM key, and the issuers of the CA certificate
+func (s *hsmSigner) getCAKey(ctx context.Context, spec *v1alpha1.ManagedHSMSpec) (*caKey, []*x509.Certificate, error) {
+	if spec == nil || spec.KeyName == "" || spec.CACertificate == "" {
+		return nil, nil, fmt.Errorf("managedHSM.keyName and managedHSM.caCertificate are required by the %s backend", v1alpha1.BackendManagedHSM)
+	}
+	certs, err := pki.DecodeX509CertificateChainBytes([]byte(spec.CACertificate))
+	if err != nil || len(certs) == 0 {
+		return nil, nil, fmt.Errorf("failed to decode CA certificate of key %s: %v", spec.KeyName, err)
+	}
+	caCert := certs[0]
+	if !caCert.IsCA {
+		return nil, nil, fmt.Errorf("certificate of key %s is not a CA certificate", spec.KeyName)
+	}
+
+	key, err := s.client.GetKey(ctx, s.hsmURL, spec.KeyName, spec.KeyVersion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get key %s: %w", spec.KeyName, keyvaultError(err))
+	}
+	if key.Key == nil || key.Key.Kid == nil {
+		return nil, nil, fmt.Errorf("key %s has no key material", spec.KeyName)
+	}
+	if key.Attributes != nil && !to.Bool(key.Attributes.Enabled) {
+		return nil, nil, fmt.Errorf("key %s is disabled", spec.KeyName)
+	}
+	if !hasKeyOperation(key.Key, "sign") {
+		return nil, nil, fmt.Errorf("key %s doesn't allow the sign operation", spec.KeyName)
+	}
+	if !publicKeyMatches(key.Key, caCert.PublicKey) {
+		return nil, nil, fmt.Errorf("CA certificate %s doesn't match the public key of key %s", caCert.Subject, spec.KeyName)
+	}
+	// sign with the version that was checked against the CA certificate
+	keyName, keyVersion, err := parseObjectID(*key.Key.Kid, "keys")
+	if err != nil {
+		return nil, nil, err
+	}
+	return &caKey{
+		cert: caCert,
+		signer: &keyvaultKey{
+			ctx:        ctx,
+			baseClient: s.client,
+			vaultURL:   s.hsmURL,
+			name:       keyName,
+			version:    keyVersion,
+			publicKey:  caCert.PublicKey,
+		},
+	}, certs[1:], nil
+}
+
+// hasKeyOperation returns true if the key allows the operation. Keys without
+// a list of operations allow all of them.
+func hasKeyOperation(key *kv.JSONWebKey, operation string) bool {
+	if key.KeyOps == nil {
+		return true
+	}
+	for _, op := range *key.KeyOps {
+		if strings.EqualFold(op, operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicKeyMatches returns true if the public part of the JSON web key is the
+// public key
+func publicKeyMatches(key *kv.JSONWebKey, publicKey crypto.PublicKey) bool {
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		n, e := decodeKeyParameter(key.N), decodeKeyParameter(key.E)
+		return n != nil && e != nil && n.Cmp(pub.N) == 0 && e.Cmp(big.NewInt(int64(pub.E))) == 0
+	case *ecdsa.PublicKey:
+		x, y := decodeKeyParameter(key.X), decodeKeyParameter(key.Y)
+		return x != nil && y != nil && x.Cmp(pub.X) == 0 && y.Cmp(pub.Y) == 0
+	default:
+		return false
+	}
+}
+
+// decodeKeyParameter decodes a base64url encoded big-endian integer of a JSON
+// web key, nil if it's missing or invalid
+func decodeKeyParameter(value *string) *big.Int {
+	if value == nil {
+		return nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*value, "="))
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"encoding/pem"
+	"testing"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+func TestManagedHSMSign(t *testing.T) {
+	server := newTestServer(t)
+	hsm := server.AddVault("hsm")
+	caCert := hsm.AddCACertificate("ca-key")
+	spec := v1alpha1.IssuerSpec{
+		KeyvaultName: "hsm",
+		Backend:      v1alpha1.BackendManagedHSM,
+		ManagedHSM: &v1alpha1.ManagedHSMSpec{
+			KeyName:       "ca-key",
+			CACertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})),
+		},
+	}
+
+	s, err := NewSigner(testCreds, spec)
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+	if err := s.CheckIssuer(context.Background(), spec); err != nil {
+		t.Fatalf("CheckIssuer() error = %v", err)
+	}
+	req, _ := newTestRequest(t, "default-cert", "example.com")
+	signed, err := s.Sign(context.Background(), req, spec)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	cert, _ := parseSignedCertificate(t, signed)
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("certificate is not signed by the CA: %v", err)
+	}
+	if got := server.TokenResources(); len(got) == 0 || got[0] != "https://managedhsm.azure.net" {
+		t.Errorf("token resources = %v, want https://managedhsm.azure.net", got)
+	}
+
+	// a CA certificate of another key fails the Ready check
+	otherCA := hsm.AddCACertificate("other-key")
+	spec.ManagedHSM.CACertificate = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCA.Raw}))
+	if err := s.CheckIssuer(context.Background(), spec); err == nil {
+		t.Error("CheckIssuer() succeeded with a CA certificate of another key")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+const (
+	// defaultIMDSEndpoint is the token endpoint of the Azure Instance Metadata
+	// Service. AKS pod identity intercepts requests to the same address.
+	defaultIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	imdsAPIVersion      = "2018-02-01"
+
+	// msiTokenRefreshWindow is how long before expiry the token is refreshed
+	msiTokenRefreshWindow = 5 * time.Minute
+	// msiMaxAttempts is the number of attempts to get a token from IMDS, which
+	// can be briefly unavailable, e.g. while pod identity assigns the identity
+	msiMaxAttempts = 5
+)
+
+// msiTokenProvider gets tokens for a system or user-assigned managed identity
+// from the instance metadata service. A user-assigned identity is selected by
+// one of its client ID, object ID or resource ID.
+type msiTokenProvider struct {
+	endpoint   string
+	resource   string
+	clientID   string
+	objectID   string
+	resourceID string
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	token adal.Token
+}
+
+var _ adal.RefresherWithContext = &msiTokenProvider{}
+
+// newMSITokenProvider returns a token provider for the managed identity of the
+// configuration with tokens for the resource. The first token is fetched when
+// the provider is first used.
+func newMSITokenProvider(config *authConfig, resource string) (*msiTokenProvider, error) {
+	endpoint := config.msiEndpoint
+	if endpoint == "" {
+		endpoint = defaultIMDSEndpoint
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid managed identity endpoint %q: %v", endpoint, err)
+	}
+	return &msiTokenProvider{
+		endpoint:   endpoint,
+		resource:   resource,
+		clientID:   config.userAssignedIdentityID,
+		objectID:   config.userAssignedIdentityObjectID,
+		resourceID: config.userAssignedIdentityResourceID,
+		httpClient: &http.Client{Timeout: 30 * time.Second, Transport: getHTTPTransport()},
+	}, nil
+}
+
+// OAuthToken returns the current access token
+func (p *msiTokenProvider) OAuthToken() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.token.AccessToken
+}
+
+// EnsureFreshWithContext refreshes the token if it expires soon
+func (p *msiTokenProvider) EnsureFreshWithContext(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.token.WillExpireIn(msiTokenRefreshWindow) {
+		return nil
+	}
+	return p.refresh(ctx, p.resource)
+}
+
+// RefreshWithContext gets a new token
+func (p *msiTokenProvider) RefreshWithContext(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.refresh(ctx, p.resource)
+}
+
+// RefreshExchangeWithContext gets a new token for another resource
+func (p *msiTokenProvider) RefreshExchangeWithContext(ctx context.Context, resource string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resource = resource
+	return p.refresh(ctx, resource)
+}
+
+func (p *msiTokenProvider) refresh(ctx context.Context, resource string) error {
+	var err error
+	backoff := time.Second
+	for attempt := 1; attempt <= msiMaxAttempts; attempt++ {
+		var retry bool
+		if retry, err = p.getToken(ctx, resource); err == nil || !retry || attempt == msiMaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %v", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// getToken requests a token from the endpoint. It returns whether the request
+// can be retried when it fails.
+func (p *msiTokenProvider) getToken(ctx context.Context, resource string) (bool, error) {
+	u, err := url.Parse(p.endpoint)
+	if err != nil {
+		return false, err
+	}
+	query := u.Query()
+	query.Set("api-version", imdsAPIVersion)
+	query.Set("resource", resource)
+	switch {
+	case p.clientID != "":
+		query.Set("client_id", p.clientID)
+	case p.objectID != "":
+		query.Set("object_id", p.objectID)
+	case p.resourceID != "":
+		query.Set("mi_res_id", p.resourceID)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to get managed identity token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, fmt.Errorf("failed to read managed identity token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return retry, fmt.Errorf("failed to get managed identity token, status code %d: %s", resp.StatusCode, string(body))
+	}
+	var token adal.Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return false, fmt.Errorf("failed to parse managed identity token: %v", err)
+	}
+	if token.AccessToken == "" {
+		return false, fmt.Errorf("managed identity token response has no access token")
+	}
+	p.token = token
+	return false, nil
+}
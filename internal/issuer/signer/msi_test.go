@@ -0,0 +1,134 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// fakeIMDS records the queries of token requests and returns a token
+type fakeIMDS struct {
+	queries []url.Values
+}
+
+func (f *fakeIMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Metadata") != "true" {
+		http.Error(w, "missing Metadata header", http.StatusBadRequest)
+		return
+	}
+	f.queries = append(f.queries, r.URL.Query())
+	expiresOn := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	fmt.Fprintf(w, `{"access_token":"token","expires_in":"3600","expires_on":"%s","resource":"%s","token_type":"Bearer"}`, expiresOn, r.URL.Query().Get("resource"))
+}
+
+func TestManagedIdentityToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      map[string][]byte
+		wantParam string
+		wantValue string
+	}{
+		{
+			name: "system-assigned",
+		},
+		{
+			name:      "client ID",
+			data:      map[string][]byte{userAssignedIdentityIDKey: []byte("client-id")},
+			wantParam: "client_id",
+			wantValue: "client-id",
+		},
+		{
+			name:      "object ID",
+			data:      map[string][]byte{userAssignedObjectIDKey: []byte("object-id")},
+			wantParam: "object_id",
+			wantValue: "object-id",
+		},
+		{
+			name:      "resource ID",
+			data:      map[string][]byte{userAssignedResourceIDKey: []byte("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id")},
+			wantParam: "mi_res_id",
+			wantValue: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imds := &fakeIMDS{}
+			server := httptest.NewServer(imds)
+			defer server.Close()
+
+			data := map[string][]byte{
+				useManagedIdentityKey: []byte("true"),
+				msiEndpointKey:        []byte(server.URL + "/metadata/identity/oauth2/token"),
+			}
+			for k, v := range tt.data {
+				data[k] = v
+			}
+			config, err := getConfigFromSecretData(data)
+			if err != nil {
+				t.Fatalf("getConfigFromSecretData() error = %v", err)
+			}
+			authorizer, err := getKeyvaultToken(config, &azure.PublicCloud, azure.PublicCloud.KeyVaultEndpoint)
+			if err != nil {
+				t.Fatalf("getKeyvaultToken() error = %v", err)
+			}
+			req, err := autorest.Prepare(&http.Request{}, authorizer.WithAuthorization())
+			if err != nil {
+				t.Fatalf("WithAuthorization() error = %v", err)
+			}
+			if got := req.Header.Get("Authorization"); got != "Bearer token" {
+				t.Errorf("Authorization = %q, want %q", got, "Bearer token")
+			}
+
+			if len(imds.queries) != 1 {
+				t.Fatalf("got %d token requests, want 1", len(imds.queries))
+			}
+			query := imds.queries[0]
+			if got, want := query.Get("resource"), "https://vault.azure.net"; got != want {
+				t.Errorf("resource = %q, want %q", got, want)
+			}
+			for _, param := range []string{"client_id", "object_id", "mi_res_id"} {
+				want := ""
+				if param == tt.wantParam {
+					want = tt.wantValue
+				}
+				if got := query.Get(param); got != want {
+					t.Errorf("%s = %q, want %q", param, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestManagedIdentityConflictingIDs(t *testing.T) {
+	_, err := getConfigFromSecretData(map[string][]byte{
+		useManagedIdentityKey:     []byte("true"),
+		userAssignedIdentityIDKey: []byte("client-id"),
+		userAssignedObjectIDKey:   []byte("object-id"),
+	})
+	if err == nil {
+		t.Errorf("getConfigFromSecretData() expected an error for conflicting identities")
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+const (
+	// DefaultCertificateNameTemplate names keyvault certificates after the
+	// cluster ID and the namespace and name of the CertificateRequest
+	DefaultCertificateNameTemplate = v1alpha1.DefaultCertificateNameTemplate
+
+	// maxCertificateNameLength is the maximum length of keyvault object names
+	maxCertificateNameLength = 127
+	// nameHashLength is the length of the hash suffix of sanitized names
+	nameHashLength = 8
+)
+
+var invalidNameChars = regexp.MustCompile(`[^-A-Za-z0-9]+`)
+
+// NameParameters are the values available in certificate name templates
+type NameParameters = v1alpha1.CertificateNameParameters
+
+// CertificateName renders the certificate name template for the parameters and
+// makes the result a valid keyvault object name. Names that aren't valid are
+// sanitized and suffixed with a hash of the rendered name, so distinct rendered
+// names never map to the same keyvault object.
+func CertificateName(nameTemplate string, params NameParameters) (string, error) {
+	tmpl, err := v1alpha1.ParseCertificateNameTemplate(nameTemplate)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, params); err != nil {
+		return "", fmt.Errorf("failed to render certificate name template: %v", err)
+	}
+	return sanitizeName(b.String()), nil
+}
+
+// validateNameTemplate checks the certificate name template of the issuer
+func validateNameTemplate(nameTemplate string) error {
+	return v1alpha1.ValidateCertificateNameTemplate(nameTemplate)
+}
+
+// sanitizeName replaces the characters keyvault doesn't allow in object names
+// and truncates the name to the keyvault length limit
+func sanitizeName(name string) string {
+	sanitized := strings.Trim(invalidNameChars.ReplaceAllString(name, "-"), "-")
+	if sanitized == name && len(name) <= maxCertificateNameLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:nameHashLength]
+	if maxLength := maxCertificateNameLength - len(suffix) - 1; len(sanitized) > maxLength {
+		sanitized = strings.TrimRight(sanitized[:maxLength], "-")
+	}
+	if sanitized == "" {
+		return suffix
+	}
+	return sanitized + "-" + suffix
+}
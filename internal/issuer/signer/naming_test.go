@@ -0,0 +1,145 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var validCertificateName = regexp.MustCompile(`^[-A-Za-z0-9]{1,127}$`)
+
+func TestCertificateNameClusterID(t *testing.T) {
+	params := NameParameters{Namespace: "default", Name: "www-example-com-1234", UID: "d6c4d7f2-0b52-4d0e-9c0c-5e0f8a6c4d01"}
+	names := map[string]string{}
+	for _, clusterID := range []string{"", "cluster-a", "cluster-b"} {
+		params.ClusterID = clusterID
+		name, err := CertificateName("", params)
+		if err != nil {
+			t.Fatalf("CertificateName() error = %v", err)
+		}
+		if !validCertificateName.MatchString(name) {
+			t.Errorf("CertificateName() = %q, not a valid keyvault name", name)
+		}
+		if other, ok := names[name]; ok {
+			t.Errorf("cluster IDs %q and %q render the same certificate name %q", other, clusterID, name)
+		}
+		names[name] = clusterID
+		if clusterID != "" && !strings.HasPrefix(name, clusterID+"-") {
+			t.Errorf("CertificateName() = %q, want the prefix %q", name, clusterID)
+		}
+	}
+
+	// without a cluster ID the names don't change from the ones of the
+	// namespace and name only
+	params.ClusterID = ""
+	got, err := CertificateName("", params)
+	if err != nil {
+		t.Fatalf("CertificateName() error = %v", err)
+	}
+	want, err := CertificateName("{{ .Namespace }}.{{ .Name }}", params)
+	if err != nil {
+		t.Fatalf("CertificateName() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("CertificateName() = %q, want %q", got, want)
+	}
+}
+
+func TestCertificateName(t *testing.T) {
+	params := NameParameters{Namespace: "default", Name: "cert", UID: "d6c4d7f2-0b52-4d0e-9c0c-5e0f8a6c4d01", ClusterID: "cluster"}
+	tests := []struct {
+		name     string
+		template string
+		params   NameParameters
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "valid name is used as is",
+			template: "{{ .ClusterID }}-{{ .Namespace }}-{{ .Name }}",
+			params:   params,
+			want:     "cluster-default-cert",
+		},
+		{
+			name:     "UID",
+			template: "{{ .UID }}",
+			params:   params,
+			want:     "d6c4d7f2-0b52-4d0e-9c0c-5e0f8a6c4d01",
+		},
+		{
+			name:     "invalid characters are replaced",
+			template: "{{ .Namespace }}.{{ .Name }}",
+			params:   params,
+			want:     "default-cert-a1493d16",
+		},
+		{
+			name:     "invalid template",
+			template: "{{ .Namespace ",
+			params:   params,
+			wantErr:  true,
+		},
+		{
+			name:     "unknown field",
+			template: "{{ .Certificate }}",
+			params:   params,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CertificateName(tt.template, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CertificateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CertificateName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "dots", input: "default.cert"},
+		{name: "leading and trailing separators", input: ".cert."},
+		{name: "too long", input: long},
+		{name: "only invalid characters", input: "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeName(tt.input)
+			if !validCertificateName.MatchString(got) {
+				t.Errorf("sanitizeName(%q) = %q, not a valid keyvault name", tt.input, got)
+			}
+			if got == tt.input {
+				t.Errorf("sanitizeName(%q) didn't append a hash", tt.input)
+			}
+		})
+	}
+	if sanitizeName("default.cert") == sanitizeName("default-cert") {
+		t.Error("sanitized names of distinct names are equal")
+	}
+	if got := sanitizeName(long[:maxCertificateNameLength]); got != long[:maxCertificateNameLength] {
+		t.Errorf("sanitizeName() = %q, want the name unchanged", got)
+	}
+}
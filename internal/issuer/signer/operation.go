@@ -0,0 +1,136 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+)
+
+const (
+	operationStatusInProgress = "inProgress"
+	operationStatusCompleted  = "completed"
+	operationStatusFailed     = "failed"
+	operationStatusCancelled  = "cancelled"
+)
+
+// ErrOperationFailed is returned when the keyvault certificate operation failed
+// or was cancelled. Signing the same request again will not succeed.
+var ErrOperationFailed = errors.New("keyvault certificate operation failed")
+
+// Request is a certificate signing request to be signed by keyvault
+type Request struct {
+	// Name is the name of the certificate in keyvault
+	Name string
+	// CSR is the PEM encoded certificate signing request
+	CSR []byte
+	// Usages is the set of key usages requested in the CertificateRequest
+	Usages []cmapi.KeyUsage
+	// IsCA is set if the CertificateRequest asks for a CA certificate
+	IsCA bool
+	// Duration is the requested validity of the certificate, zero if not set
+	Duration time.Duration
+	// OperationID is the request ID of the keyvault certificate operation
+	// started by a previous call to Sign. It is empty for new requests.
+	OperationID string
+}
+
+// PendingError is returned by Sign while keyvault is still issuing the
+// certificate. Sign must be called again with the OperationID set in the
+// Request to complete the request.
+type PendingError struct {
+	// OperationID is the request ID of the keyvault certificate operation
+	OperationID string
+	// Details is the status details reported by keyvault
+	Details string
+}
+
+func (e *PendingError) Error() string {
+	if e.Details == "" {
+		return fmt.Sprintf("keyvault certificate operation %s is in progress", e.OperationID)
+	}
+	return fmt.Sprintf("keyvault certificate operation %s is in progress: %s", e.OperationID, e.Details)
+}
+
+// checkOperation returns the certificate once the keyvault certificate operation
+// for the request has completed
+func (s *caSigner) checkOperation(ctx context.Context, req Request) (*SignedCertificate, error) {
+	op, err := s.baseClient.GetCertificateOperation(ctx, s.vaultURL, req.Name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: operation %s for certificate %s not found", ErrOperationFailed, req.OperationID, req.Name)
+		}
+		return nil, fmt.Errorf("failed to get certificate operation %s: %w", req.Name, keyvaultError(err))
+	}
+	if requestID := to.String(op.RequestID); requestID != req.OperationID {
+		return nil, fmt.Errorf("%w: operation %s for certificate %s was superseded by operation %s", ErrOperationFailed, req.OperationID, req.Name, requestID)
+	}
+	return s.operationResult(ctx, req.Name, op)
+}
+
+// operationResult returns the certificate of a completed operation, a PendingError
+// for an operation in progress and ErrOperationFailed for a failed operation
+func (s *caSigner) operationResult(ctx context.Context, name string, op kv.CertificateOperation) (*SignedCertificate, error) {
+	status := to.String(op.Status)
+	switch {
+	case strings.EqualFold(status, operationStatusInProgress):
+		return nil, &PendingError{
+			OperationID: to.String(op.RequestID),
+			Details:     to.String(op.StatusDetails),
+		}
+	case strings.EqualFold(status, operationStatusCompleted):
+		return s.getSignedCertificate(ctx, name)
+	case strings.EqualFold(status, operationStatusFailed), strings.EqualFold(status, operationStatusCancelled):
+		message := to.String(op.StatusDetails)
+		if op.Error != nil && op.Error.Message != nil {
+			message = *op.Error.Message
+		}
+		return nil, fmt.Errorf("%w: operation %s for certificate %s is %s: %s", ErrOperationFailed, to.String(op.RequestID), name, status, message)
+	default:
+		return nil, fmt.Errorf("unexpected status %q of operation %s for certificate %s", status, to.String(op.RequestID), name)
+	}
+}
+
+// getSignedCertificate returns the latest version of the certificate along with
+// its issuing chain
+func (s *caSigner) getSignedCertificate(ctx context.Context, name string) (*SignedCertificate, error) {
+	certBundle, err := s.baseClient.GetCertificate(ctx, s.vaultURL, name, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate %s: %w", name, keyvaultError(err))
+	}
+	if certBundle.Cer == nil {
+		return nil, fmt.Errorf("certificate %s has no content", name)
+	}
+	cert, err := x509.ParseCertificate(*certBundle.Cer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %v", name, err)
+	}
+	return newSignedCertificate(cert, s.resolveChain(ctx, cert, to.String(certBundle.Sid))), nil
+}
+
+// isNotFound returns true if the keyvault request failed with 404
+func isNotFound(err error) bool {
+	return errors.Is(keyvaultError(err), ErrNotFound)
+}
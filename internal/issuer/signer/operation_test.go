@@ -0,0 +1,90 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+func TestKeyVaultSign(t *testing.T) {
+	server := newTestServer(t)
+	vault := server.AddVault("kv-sign")
+	issuerCA := vault.AddIssuer("issuer")
+	spec := v1alpha1.IssuerSpec{KeyvaultName: "kv-sign", IssuerName: "issuer"}
+
+	s, err := NewSigner(testCreds, spec)
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+	if err := s.CheckIssuer(context.Background(), spec); err != nil {
+		t.Fatalf("CheckIssuer() error = %v", err)
+	}
+
+	req, _ := newTestRequest(t, "default-cert", "example.com")
+	_, err = s.Sign(context.Background(), req, spec)
+	var pending *PendingError
+	if !errors.As(err, &pending) {
+		t.Fatalf("Sign() error = %v, want PendingError", err)
+	}
+	req.OperationID = pending.OperationID
+	signed, err := s.Sign(context.Background(), req, spec)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	cert, ca := parseSignedCertificate(t, signed)
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "example.com" {
+		t.Errorf("DNSNames = %v, want [example.com]", cert.DNSNames)
+	}
+	if !ca.Equal(issuerCA) {
+		t.Errorf("CA = %s, want %s", ca.Subject, issuerCA.Subject)
+	}
+	if got := server.TokenResources(); len(got) == 0 || got[0] != "https://vault.azure.net" {
+		t.Errorf("token resources = %v, want https://vault.azure.net", got)
+	}
+}
+
+func TestKeyVaultSupersededOperation(t *testing.T) {
+	server := newTestServer(t)
+	server.AddVault("kv-superseded").AddIssuer("issuer")
+	spec := v1alpha1.IssuerSpec{KeyvaultName: "kv-superseded", IssuerName: "issuer"}
+	s, err := NewSigner(testCreds, spec)
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+
+	req, _ := newTestRequest(t, "default-cert", "example.com")
+	_, err = s.Sign(context.Background(), req, spec)
+	var pending *PendingError
+	if !errors.As(err, &pending) {
+		t.Fatalf("Sign() error = %v, want PendingError", err)
+	}
+
+	// another operation was started for the certificate since the request
+	// recorded its operation
+	req.OperationID = pending.OperationID + "-stale"
+	_, err = s.Sign(context.Background(), req, spec)
+	if !errors.Is(err, ErrOperationFailed) {
+		t.Fatalf("Sign() error = %v, want %v", err, ErrOperationFailed)
+	}
+	if !IsPermanent(err) {
+		t.Error("IsPermanent() = false, want true")
+	}
+}
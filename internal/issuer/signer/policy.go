@@ -0,0 +1,226 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// ErrUnsupportedRequest is returned when the certificate request asks for something
+// that can't be expressed in a keyvault certificate policy
+var ErrUnsupportedRequest = errors.New("certificate request is not supported by keyvault")
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidUPN            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+)
+
+// keyUsages maps cert-manager key usages to keyvault key usages
+var keyUsages = map[cmapi.KeyUsage]kv.KeyUsageType{
+	cmapi.UsageSigning:            kv.DigitalSignature,
+	cmapi.UsageDigitalSignature:   kv.DigitalSignature,
+	cmapi.UsageContentCommittment: kv.NonRepudiation,
+	cmapi.UsageKeyEncipherment:    kv.KeyEncipherment,
+	cmapi.UsageKeyAgreement:       kv.KeyAgreement,
+	cmapi.UsageDataEncipherment:   kv.DataEncipherment,
+	cmapi.UsageCertSign:           kv.KeyCertSign,
+	cmapi.UsageCRLSign:            kv.CRLSign,
+	cmapi.UsageEncipherOnly:       kv.EncipherOnly,
+	cmapi.UsageDecipherOnly:       kv.DecipherOnly,
+}
+
+// extKeyUsages maps cert-manager extended key usages to their OIDs
+var extKeyUsages = map[cmapi.KeyUsage]string{
+	cmapi.UsageAny:             "2.5.29.37.0",
+	cmapi.UsageServerAuth:      "1.3.6.1.5.5.7.3.1",
+	cmapi.UsageClientAuth:      "1.3.6.1.5.5.7.3.2",
+	cmapi.UsageCodeSigning:     "1.3.6.1.5.5.7.3.3",
+	cmapi.UsageEmailProtection: "1.3.6.1.5.5.7.3.4",
+	cmapi.UsageSMIME:           "1.3.6.1.5.5.7.3.4",
+	cmapi.UsageIPsecEndSystem:  "1.3.6.1.5.5.7.3.5",
+	cmapi.UsageIPsecTunnel:     "1.3.6.1.5.5.7.3.6",
+	cmapi.UsageIPsecUser:       "1.3.6.1.5.5.7.3.7",
+	cmapi.UsageTimestamping:    "1.3.6.1.5.5.7.3.8",
+	cmapi.UsageOCSPSigning:     "1.3.6.1.5.5.7.3.9",
+	cmapi.UsageMicrosoftSGC:    "1.3.6.1.4.1.311.10.3.3",
+	cmapi.UsageNetscapeSGC:     "2.16.840.1.113730.4.1",
+}
+
+// newCertificatePolicy returns the keyvault certificate policy for the CSR and the
+// usages requested in the CertificateRequest. ErrUnsupportedRequest is returned
+// if the request can't be expressed in a keyvault certificate policy.
+func newCertificatePolicy(csr *x509.CertificateRequest, req Request, issuerSpec v1alpha1.IssuerSpec, issuerName string) (*kv.CertificatePolicy, error) {
+	if req.IsCA {
+		return nil, fmt.Errorf("%w: CA certificates can't be requested", ErrUnsupportedRequest)
+	}
+	if len(csr.IPAddresses) > 0 {
+		return nil, fmt.Errorf("%w: IP address subject alternative names %v", ErrUnsupportedRequest, csr.IPAddresses)
+	}
+	if len(csr.URIs) > 0 {
+		return nil, fmt.Errorf("%w: URI subject alternative names %v", ErrUnsupportedRequest, csr.URIs)
+	}
+
+	upns, err := UserPrincipalNames(csr)
+	if err != nil {
+		return nil, err
+	}
+	sans := &kv.SubjectAlternativeNames{}
+	if len(csr.DNSNames) > 0 {
+		sans.DNSNames = &csr.DNSNames
+	}
+	if len(csr.EmailAddresses) > 0 {
+		sans.Emails = &csr.EmailAddresses
+	}
+	if len(upns) > 0 {
+		sans.Upns = &upns
+	}
+
+	// keyvault requires a subject, cert-manager leaves it empty unless a common
+	// name or other subject fields are set in the Certificate
+	subject := csr.Subject.String()
+	if subject == "" {
+		switch {
+		case len(csr.DNSNames) > 0:
+			subject = "CN=" + csr.DNSNames[0]
+		case len(csr.EmailAddresses) > 0:
+			subject = "CN=" + csr.EmailAddresses[0]
+		case len(upns) > 0:
+			subject = "CN=" + upns[0]
+		default:
+			return nil, fmt.Errorf("%w: CSR has no subject or subject alternative names", ErrUnsupportedRequest)
+		}
+	}
+
+	validity, err := validityInMonths(req.Duration, issuerSpec)
+	if err != nil {
+		return nil, err
+	}
+	keyProps, err := keyProperties(csr, issuerSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	x509Props := &kv.X509CertificateProperties{
+		Subject:                 to.StringPtr(subject),
+		SubjectAlternativeNames: sans,
+		ValidityInMonths:        to.Int32Ptr(validity),
+	}
+	// without usages keyvault applies its defaults
+	if len(req.Usages) > 0 {
+		var usages []kv.KeyUsageType
+		var ekus []string
+		for _, usage := range req.Usages {
+			if keyUsage, ok := keyUsages[usage]; ok {
+				usages = appendKeyUsage(usages, keyUsage)
+				continue
+			}
+			if eku, ok := extKeyUsages[usage]; ok {
+				ekus = appendString(ekus, eku)
+				continue
+			}
+			return nil, fmt.Errorf("%w: usage %q", ErrUnsupportedRequest, usage)
+		}
+		if len(usages) > 0 {
+			x509Props.KeyUsage = &usages
+		}
+		if len(ekus) > 0 {
+			x509Props.Ekus = &ekus
+		}
+	}
+
+	return &kv.CertificatePolicy{
+		KeyProperties:             keyProps,
+		X509CertificateProperties: x509Props,
+		IssuerParameters: &kv.IssuerParameters{
+			Name: to.StringPtr(issuerName),
+		},
+	}, nil
+}
+
+// UserPrincipalNames returns the user principal names in the otherName subject
+// alternative names of the CSR, which aren't parsed by crypto/x509. otherNames
+// of other types fail with ErrUnsupportedRequest.
+func UserPrincipalNames(csr *x509.CertificateRequest) ([]string, error) {
+	var upns []string
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var seq asn1.RawValue
+		if rest, err := asn1.Unmarshal(ext.Value, &seq); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("%w: malformed subject alternative names", ErrUnsupportedRequest)
+		}
+		for rest := seq.Bytes; len(rest) > 0; {
+			var name asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				return nil, fmt.Errorf("%w: malformed subject alternative names", ErrUnsupportedRequest)
+			}
+			// otherName is [0] in GeneralName
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+			// the otherName is an implicitly tagged sequence of the type
+			// id and an explicitly tagged value
+			var typeID asn1.ObjectIdentifier
+			var value asn1.RawValue
+			valueBytes, err := asn1.Unmarshal(name.Bytes, &typeID)
+			if err != nil {
+				return nil, fmt.Errorf("%w: malformed otherName subject alternative name", ErrUnsupportedRequest)
+			}
+			if _, err := asn1.Unmarshal(valueBytes, &value); err != nil || value.Class != asn1.ClassContextSpecific || value.Tag != 0 {
+				return nil, fmt.Errorf("%w: malformed otherName subject alternative name", ErrUnsupportedRequest)
+			}
+			if !typeID.Equal(oidUPN) {
+				return nil, fmt.Errorf("%w: otherName subject alternative name of type %v", ErrUnsupportedRequest, typeID)
+			}
+			var upn string
+			if _, err := asn1.UnmarshalWithParams(value.Bytes, &upn, "utf8"); err != nil {
+				return nil, fmt.Errorf("%w: malformed user principal name", ErrUnsupportedRequest)
+			}
+			upns = append(upns, upn)
+		}
+	}
+	return upns, nil
+}
+
+func appendKeyUsage(usages []kv.KeyUsageType, usage kv.KeyUsageType) []kv.KeyUsageType {
+	for _, u := range usages {
+		if u == usage {
+			return usages
+		}
+	}
+	return append(usages, usage)
+}
+
+func appendString(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// upnExtension returns a subject alternative name extension with the user
+// principal name as otherName
+func upnExtension(t *testing.T, upn string) pkix.Extension {
+	t.Helper()
+	value, err := asn1.MarshalWithParams(upn, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicitValue, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeID, err := asn1.Marshal(oidUPN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sans, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: append(typeID, explicitValue...)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: sans}
+}
+
+func TestNewCertificatePolicy(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		template  *x509.CertificateRequest
+		req       Request
+		wantX509  *kv.X509CertificateProperties
+		wantError bool
+	}{
+		{
+			name:     "subject and DNS names",
+			template: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "www.example.com", Organization: []string{"Example"}}, DNSNames: []string{"www.example.com", "example.com"}},
+			wantX509: &kv.X509CertificateProperties{
+				Subject:                 to.StringPtr("CN=www.example.com,O=Example"),
+				SubjectAlternativeNames: &kv.SubjectAlternativeNames{DNSNames: &[]string{"www.example.com", "example.com"}},
+				ValidityInMonths:        to.Int32Ptr(3),
+			},
+		},
+		{
+			name:     "subject from the first DNS name",
+			template: &x509.CertificateRequest{DNSNames: []string{"example.com"}},
+			wantX509: &kv.X509CertificateProperties{
+				Subject:                 to.StringPtr("CN=example.com"),
+				SubjectAlternativeNames: &kv.SubjectAlternativeNames{DNSNames: &[]string{"example.com"}},
+				ValidityInMonths:        to.Int32Ptr(3),
+			},
+		},
+		{
+			name:     "email addresses",
+			template: &x509.CertificateRequest{EmailAddresses: []string{"user@example.com"}},
+			wantX509: &kv.X509CertificateProperties{
+				Subject:                 to.StringPtr("CN=user@example.com"),
+				SubjectAlternativeNames: &kv.SubjectAlternativeNames{Emails: &[]string{"user@example.com"}},
+				ValidityInMonths:        to.Int32Ptr(3),
+			},
+		},
+		{
+			name:     "user principal names",
+			template: &x509.CertificateRequest{ExtraExtensions: []pkix.Extension{upnExtension(t, "user@example.com")}},
+			wantX509: &kv.X509CertificateProperties{
+				Subject:                 to.StringPtr("CN=user@example.com"),
+				SubjectAlternativeNames: &kv.SubjectAlternativeNames{Upns: &[]string{"user@example.com"}},
+				ValidityInMonths:        to.Int32Ptr(3),
+			},
+		},
+		{
+			name:     "key usages and extended key usages",
+			template: &x509.CertificateRequest{DNSNames: []string{"example.com"}},
+			req: Request{Usages: []cmapi.KeyUsage{
+				cmapi.UsageSigning, cmapi.UsageDigitalSignature, cmapi.UsageKeyEncipherment,
+				cmapi.UsageServerAuth, cmapi.UsageEmailProtection, cmapi.UsageSMIME,
+			}},
+			wantX509: &kv.X509CertificateProperties{
+				Subject:                 to.StringPtr("CN=example.com"),
+				SubjectAlternativeNames: &kv.SubjectAlternativeNames{DNSNames: &[]string{"example.com"}},
+				ValidityInMonths:        to.Int32Ptr(3),
+				KeyUsage:                &[]kv.KeyUsageType{kv.DigitalSignature, kv.KeyEncipherment},
+				Ekus:                    &[]string{"1.3.6.1.5.5.7.3.1", "1.3.6.1.5.5.7.3.4"},
+			},
+		},
+		{
+			name:      "CA certificate",
+			template:  &x509.CertificateRequest{DNSNames: []string{"example.com"}},
+			req:       Request{IsCA: true},
+			wantError: true,
+		},
+		{
+			name:      "IP addresses",
+			template:  &x509.CertificateRequest{DNSNames: []string{"example.com"}, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			wantError: true,
+		},
+		{
+			name:      "URIs",
+			template:  &x509.CertificateRequest{DNSNames: []string{"example.com"}, URIs: []*url.URL{{Scheme: "spiffe", Host: "example.com"}}},
+			wantError: true,
+		},
+		{
+			name:      "unknown usage",
+			template:  &x509.CertificateRequest{DNSNames: []string{"example.com"}},
+			req:       Request{Usages: []cmapi.KeyUsage{"unknown"}},
+			wantError: true,
+		},
+		{
+			name:      "no subject or subject alternative names",
+			template:  &x509.CertificateRequest{},
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := x509.CreateCertificateRequest(rand.Reader, tt.template, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			csr, err := x509.ParseCertificateRequest(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			policy, err := newCertificatePolicy(csr, tt.req, v1alpha1.IssuerSpec{}, "issuer")
+			if tt.wantError {
+				if !errors.Is(err, ErrUnsupportedRequest) {
+					t.Fatalf("newCertificatePolicy() error = %v, want %v", err, ErrUnsupportedRequest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newCertificatePolicy() error = %v", err)
+			}
+			if !reflect.DeepEqual(policy.X509CertificateProperties, tt.wantX509) {
+				t.Errorf("X509CertificateProperties = %+v, want %+v", policy.X509CertificateProperties, tt.wantX509)
+			}
+			if got := to.String(policy.IssuerParameters.Name); got != "issuer" {
+				t.Errorf("issuer name = %q, want issuer", got)
+			}
+		})
+	}
+}
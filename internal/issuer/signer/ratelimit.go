@@ -0,0 +1,131 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultVaultQPS is the default rate of requests sent to a vault
+	DefaultVaultQPS = 10
+	// DefaultVaultBurst is the default burst of requests sent to a vault
+	DefaultVaultBurst = 20
+)
+
+var (
+	vaultLimitersMu sync.Mutex
+	vaultLimiters   = map[string]*vaultLimiter{}
+	vaultQPS        = rate.Limit(DefaultVaultQPS)
+	vaultBurst      = DefaultVaultBurst
+)
+
+// SetVaultRateLimit sets the rate and burst of requests sent to each vault. It
+// must be called before the first signer is created.
+func SetVaultRateLimit(qps float64, burst int) {
+	vaultLimitersMu.Lock()
+	defer vaultLimitersMu.Unlock()
+	vaultQPS = rate.Limit(qps)
+	vaultBurst = burst
+}
+
+// vaultLimiter limits the requests to a vault. It is shared by all signers of
+// the vault, so issuers using the same vault share its request budget.
+type vaultLimiter struct {
+	limiter *rate.Limiter
+
+	mu           sync.Mutex
+	blockedUntil time.Time
+}
+
+// getVaultLimiter returns the limiter of the vault
+func getVaultLimiter(vaultURL string) *vaultLimiter {
+	vaultLimitersMu.Lock()
+	defer vaultLimitersMu.Unlock()
+	l, ok := vaultLimiters[vaultURL]
+	if !ok {
+		l = &vaultLimiter{limiter: rate.NewLimiter(vaultQPS, vaultBurst)}
+		vaultLimiters[vaultURL] = l
+	}
+	return l
+}
+
+// block holds back all requests to the vault until the time has passed
+func (l *vaultLimiter) block(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// withRateLimit is a SendDecorator that waits for the limiter of the vault
+// before sending a request. A throttled response blocks the vault for the
+// delay requested in its Retry-After header; requests sent while the vault is
+// blocked fail with ErrThrottled and the remaining delay instead of waiting.
+func withRateLimit(l *vaultLimiter) autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			l.mu.Lock()
+			blockedUntil := l.blockedUntil
+			l.mu.Unlock()
+			if delay := time.Until(blockedUntil); delay > 0 {
+				return nil, &Error{
+					Kind:       ErrThrottled,
+					RetryAfter: delay,
+					Err:        fmt.Errorf("requests to %s are held back for %s", r.URL.Host, delay.Round(time.Second)),
+				}
+			}
+			if err := l.limiter.Wait(r.Context()); err != nil {
+				return nil, err
+			}
+			resp, err := s.Do(r)
+			if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+				l.block(time.Now().Add(retryAfter(resp)))
+			}
+			return resp, err
+		})
+	}
+}
+
+// retryAfter returns the delay requested by the Retry-After header of the
+// response, in seconds or as an HTTP date, or zero if there is none
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+	"github.com/aramase/azure-external-issuer/internal/testutil/fakekeyvault"
+)
+
+func TestKeyVaultThrottled(t *testing.T) {
+	server := newTestServer(t)
+	server.AddVault("kv-throttled").AddIssuer("issuer")
+	server.Inject(fakekeyvault.Fault{
+		Method:     http.MethodPost,
+		Path:       "/certificates/*/create",
+		StatusCode: http.StatusTooManyRequests,
+		RetryAfter: time.Second,
+		Times:      1,
+	})
+	spec := v1alpha1.IssuerSpec{KeyvaultName: "kv-throttled", IssuerName: "issuer"}
+	s, err := NewSigner(testCreds, spec)
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+
+	req, _ := newTestRequest(t, "default-cert", "example.com")
+	_, err = s.Sign(context.Background(), req, spec)
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("Sign() error = %v, want %v", err, ErrThrottled)
+	}
+	if IsPermanent(err) {
+		t.Error("IsPermanent() = true, want false")
+	}
+	var kvErr *Error
+	if !errors.As(err, &kvErr) || kvErr.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want %s", kvErr, time.Second)
+	}
+	if creates := countRequests(server, "POST /certificates/default-cert/create"); creates != 1 {
+		t.Errorf("create requests = %d, want 1, throttled requests must not be retried by the client", creates)
+	}
+
+	// requests to the vault fail without being sent until the delay has
+	// passed, rather than blocking the caller
+	_, err = s.Sign(context.Background(), req, spec)
+	if !errors.As(err, &kvErr) || !errors.Is(err, ErrThrottled) {
+		t.Fatalf("Sign() error = %v, want %v", err, ErrThrottled)
+	}
+	if kvErr.RetryAfter <= 0 || kvErr.RetryAfter > time.Second {
+		t.Errorf("RetryAfter = %s, want the remaining delay", kvErr.RetryAfter)
+	}
+	if creates := countRequests(server, "POST /certificates/default-cert/create"); creates != 1 {
+		t.Errorf("create requests = %d, want 1 while the vault is throttled", creates)
+	}
+
+	time.Sleep(kvErr.RetryAfter)
+	_, err = s.Sign(context.Background(), req, spec)
+	var pending *PendingError
+	if !errors.As(err, &pending) {
+		t.Fatalf("Sign() error = %v, want PendingError", err)
+	}
+	if creates := countRequests(server, "POST /certificates/default-cert/create"); creates != 2 {
+		t.Errorf("create requests = %d, want 2", creates)
+	}
+}
+
+func countRequests(server *fakekeyvault.Server, request string) int {
+	count := 0
+	for _, r := range server.Requests() {
+		if r == request {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{
+			name: "no header",
+		},
+		{
+			name:   "seconds",
+			header: "30",
+			want:   30 * time.Second,
+		},
+		{
+			name:   "zero seconds",
+			header: "0",
+		},
+		{
+			name:   "negative seconds",
+			header: "-5",
+		},
+		{
+			name:   "HTTP date",
+			header: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat),
+			want:   time.Hour,
+		},
+		{
+			name:   "HTTP date in the past",
+			header: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat),
+		},
+		{
+			name:   "invalid",
+			header: "soon",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got := retryAfter(resp)
+			// HTTP dates have a resolution of a second and are relative to now
+			if got < tt.want-2*time.Second || got > tt.want {
+				t.Errorf("retryAfter() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+	if got := retryAfter(nil); got != 0 {
+		t.Errorf("retryAfter(nil) = %s, want 0", got)
+	}
+}
+
+func TestKeySignThrottled(t *testing.T) {
+	backends := map[string]string{v1alpha1.BackendKeyVaultCA: "kv-sign-throttled", v1alpha1.BackendManagedHSM: "hsm-sign-throttled"}
+	for backend, vaultName := range backends {
+		t.Run(backend, func(t *testing.T) {
+			server := newTestServer(t)
+			spec := newKeySignerSpec(server.AddVault(vaultName), vaultName, backend)
+			server.Inject(fakekeyvault.Fault{
+				Method:     http.MethodPost,
+				Path:       "/keys/*/*/sign",
+				StatusCode: http.StatusTooManyRequests,
+				RetryAfter: time.Second,
+				Times:      1,
+			})
+			s, err := NewSigner(testCreds, spec)
+			if err != nil {
+				t.Fatalf("NewSigner() error = %v", err)
+			}
+
+			req, _ := newTestRequest(t, "default-cert", "example.com")
+			_, err = s.Sign(context.Background(), req, spec)
+			var kvErr *Error
+			if !errors.As(err, &kvErr) || !errors.Is(err, ErrThrottled) {
+				t.Fatalf("Sign() error = %v, want %v", err, ErrThrottled)
+			}
+			if kvErr.RetryAfter != time.Second {
+				t.Errorf("RetryAfter = %s, want %s", kvErr.RetryAfter, time.Second)
+			}
+
+			// the vault is held back for the delay requested by keyvault
+			signs := countSignRequests(server)
+			_, err = s.Sign(context.Background(), req, spec)
+			if !errors.As(err, &kvErr) || !errors.Is(err, ErrThrottled) {
+				t.Fatalf("Sign() error = %v, want %v", err, ErrThrottled)
+			}
+			if kvErr.RetryAfter <= 0 || kvErr.RetryAfter > time.Second {
+				t.Errorf("RetryAfter = %s, want the remaining delay", kvErr.RetryAfter)
+			}
+			if got := countSignRequests(server); got != signs {
+				t.Errorf("sign requests = %d, want %d while the vault is throttled", got, signs)
+			}
+
+			time.Sleep(kvErr.RetryAfter)
+			if _, err := s.Sign(context.Background(), req, spec); err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+		})
+	}
+}
+
+// countSignRequests counts the requests of the keyvault sign operation
+func countSignRequests(server *fakekeyvault.Server) int {
+	count := 0
+	for _, r := range server.Requests() {
+		if ok, _ := path.Match("POST /keys/*/*/sign", r); ok {
+			count++
+		}
+	}
+	return count
+}
@@ -18,12 +18,10 @@ package signer
 
 import (
 	"context"
-	"encoding/pem"
 	"fmt"
-	"regexp"
-	"time"
+	"os"
 
-	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
@@ -32,61 +30,117 @@ import (
 	"github.com/jetstack/cert-manager/pkg/util/pki"
 )
 
+// HealthChecker checks that the certificate authority of an issuer is usable
+type HealthChecker interface {
+	CheckIssuer(context.Context, v1alpha1.IssuerSpec) error
+}
+
 // Signer is an abstraction of the certificate authority
 type Signer interface {
-	Sign(context.Context, []byte, string, v1alpha1.IssuerSpec) ([]byte, error)
-	CheckIssuer(context.Context, string) error
+	HealthChecker
+	Sign(context.Context, Request, v1alpha1.IssuerSpec) (*SignedCertificate, error)
+}
+
+func init() {
+	RegisterBackend(v1alpha1.BackendKeyVault, newCASigner)
+	RegisterBackend(v1alpha1.BackendKeyVaultCA, newKeyCASigner)
 }
 
+// caSigner issues certificates with a keyvault certificate issuer
 type caSigner struct {
 	baseClient kv.BaseClient
 	vaultURL   string
 }
 
-func NewSigner(creds map[string][]byte, vaultName string) (Signer, error) {
+func newCASigner(creds map[string][]byte, issuerSpec v1alpha1.IssuerSpec) (Signer, error) {
+	kvClient, vaultURL, err := newKeyvaultClient(creds, issuerSpec.KeyvaultName)
+	if err != nil {
+		return nil, err
+	}
+	return &caSigner{
+		baseClient: kvClient,
+		vaultURL:   vaultURL,
+	}, nil
+}
+
+// newKeyvaultClient returns an authorized client for the vault
+func newKeyvaultClient(creds map[string][]byte, vaultName string) (kv.BaseClient, string, error) {
 	kvClient := kv.New()
 	err := kvClient.AddToUserAgent("cert-manager-issuer")
 	if err != nil {
-		return nil, fmt.Errorf("failed to add user agent to keyvault client, error: %+v", err)
+		return kv.BaseClient{}, "", fmt.Errorf("failed to add user agent to keyvault client, error: %+v", err)
 	}
 	// get auth config
 	config, err := getConfigFromSecretData(creds)
 	if err != nil {
-		return nil, err
+		return kv.BaseClient{}, "", err
 	}
 	// get azure cloud environment name
 	env, err := parseCloudEnvironment(config.cloud)
 	if err != nil {
-		return nil, err
+		return kv.BaseClient{}, "", err
 	}
 	// get keyvault url
-	vaultURL, err := getVaultURL(env, vaultName)
+	vaultURL, err := getVaultURL(env.KeyVaultDNSSuffix, vaultName)
 	if err != nil {
-		return nil, err
+		return kv.BaseClient{}, "", err
 	}
-	authorizer, err := getKeyvaultToken(config, env)
-	if err != nil {
-		return nil, err
+	if err := authorizeClient(&kvClient.Client, config, env, *vaultURL, env.KeyVaultEndpoint); err != nil {
+		return kv.BaseClient{}, "", err
 	}
-	kvClient.Authorizer = authorizer
+	return kvClient, *vaultURL, nil
+}
 
-	return &caSigner{
-		baseClient: kvClient,
-		vaultURL:   *vaultURL,
-	}, nil
+// authorizeClient sets an authorizer for the resource on the client and rate
+// limits the requests it sends to the vault
+func authorizeClient(client *autorest.Client, config *authConfig, env *azure.Environment, vaultURL, resource string) error {
+	authorizer, err := getKeyvaultToken(config, env, resource)
+	if err != nil {
+		return err
+	}
+	client.Authorizer = authorizer
+	// requests are rate limited per vault and failed requests aren't retried
+	// by the client. Throttled requests return ErrThrottled with the delay
+	// requested by keyvault, so the controller requeues the CertificateRequest
+	// instead of blocking a worker while it backs off.
+	client.Sender = newSender(withMetrics(), withRateLimit(getVaultLimiter(vaultURL)))
+	client.RetryAttempts = 0
+	return nil
 }
 
 // CheckIssuer gets the issuer name provided in the Issuer/ClusterIssuer custom resource
-// use to validate the credentials have permissions to access the issuer and issuer exists
-func (s *caSigner) CheckIssuer(ctx context.Context, issuerName string) error {
-	_, err := s.baseClient.GetCertificateIssuer(ctx, s.vaultURL, issuerName)
-	return err
+// use to validate the credentials have permissions to access the issuer and issuer exists.
+func (s *caSigner) CheckIssuer(ctx context.Context, issuerSpec v1alpha1.IssuerSpec) error {
+	if err := validateValidity(issuerSpec); err != nil {
+		return err
+	}
+	if err := validateKeyProperties(issuerSpec); err != nil {
+		return err
+	}
+	if err := validateNameTemplate(issuerSpec.CertificateNameTemplate); err != nil {
+		return err
+	}
+	// self-signed certificates don't use a keyvault issuer, so only validate
+	// the credentials can access certificates in the vault
+	if issuerSpec.IsSelfSigned {
+		_, err := s.baseClient.GetCertificates(ctx, s.vaultURL, to.Int32Ptr(1), to.BoolPtr(false))
+		return keyvaultError(err)
+	}
+	_, err := s.baseClient.GetCertificateIssuer(ctx, s.vaultURL, issuerSpec.IssuerName)
+	return keyvaultError(err)
 }
 
-func (s *caSigner) Sign(ctx context.Context, certificateSigningRequest []byte, name string, issuerSpec v1alpha1.IssuerSpec) ([]byte, error) {
-	csr, err := pki.DecodeX509CertificateRequestBytes(certificateSigningRequest)
+// Sign signs the certificate request. Certificates issued by a keyvault issuer are
+// issued asynchronously: the first call creates the certificate in keyvault and
+// returns a PendingError with the operation to poll in subsequent calls.
+func (s *caSigner) Sign(ctx context.Context, req Request, issuerSpec v1alpha1.IssuerSpec) (*SignedCertificate, error) {
+	csr, err := pki.DecodeX509CertificateRequestBytes(req.CSR)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode CSR: %+v", err)
+		return nil, fmt.Errorf("%w: failed to decode CSR: %v", ErrInvalidRequest, err)
+	}
+
+	if req.OperationID != "" {
+		return s.checkOperation(ctx, req)
 	}
 
 	issuerName := issuerSpec.IssuerName
@@ -94,45 +148,20 @@ func (s *caSigner) Sign(ctx context.Context, certificateSigningRequest []byte, n
 		issuerName = "Self"
 	}
 
-	fmt.Println("csr.RawSubject ", string(csr.RawSubject))
-	fmt.Println("csr.DNSNames ", csr.DNSNames)
+	policy, err := newCertificatePolicy(csr, req, issuerSpec, issuerName)
+	if err != nil {
+		return nil, err
+	}
 	params := kv.CertificateCreateParameters{
-		CertificatePolicy: &kv.CertificatePolicy{
-			X509CertificateProperties: &kv.X509CertificateProperties{
-				// Subject:                 to.StringPtr(string(csr.RawSubject)),
-				SubjectAlternativeNames: &kv.SubjectAlternativeNames{DNSNames: &csr.DNSNames},
-			},
-			IssuerParameters: &kv.IssuerParameters{
-				Name: to.StringPtr(issuerName),
-			},
-		},
+		CertificatePolicy:     policy,
 		CertificateAttributes: &kv.CertificateAttributes{},
 	}
 
-	_, err = s.baseClient.CreateCertificate(ctx, s.vaultURL, name, params)
+	op, err := s.baseClient.CreateCertificate(ctx, s.vaultURL, req.Name, params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create certificate %s: %v", name, err)
+		return nil, fmt.Errorf("failed to create certificate %s: %w", req.Name, keyvaultError(err))
 	}
-
-	var pemData []byte
-	var certBundle kv.CertificateBundle
-	for {
-		certBundle, err = s.baseClient.GetCertificate(ctx, s.vaultURL, name, "")
-		if err != nil {
-			return nil, fmt.Errorf("failed to get certificate %s: %v", name, err)
-		}
-		if *certBundle.Attributes.Enabled {
-			break
-		}
-		time.Sleep(5 * time.Second)
-	}
-
-	certBlock := &pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: *certBundle.Cer,
-	}
-	pemData = append(pemData, pem.EncodeToMemory(certBlock)...)
-	return pemData, nil
+	return s.operationResult(ctx, req.Name, op)
 }
 
 // parseCloudEnvironment returns azure environment by name
@@ -147,25 +176,18 @@ func parseCloudEnvironment(cloudName string) (*azure.Environment, error) {
 	return &env, err
 }
 
-func getVaultURL(azureEnvironment *azure.Environment, vaultName string) (vaultURL *string, err error) {
-	// Key Vault name must be a 3-24 character string
-	if len(vaultName) < 3 || len(vaultName) > 24 {
-		return nil, fmt.Errorf("invalid vault name: %q, must be between 3 and 24 chars", vaultName)
-	}
-	// See docs for validation spec: https://docs.microsoft.com/en-us/azure/key-vault/about-keys-secrets-and-certificates#objects-identifiers-and-versioning
-	isValid := regexp.MustCompile(`^[-A-Za-z0-9]+$`).MatchString
-	if !isValid(vaultName) {
-		return nil, fmt.Errorf("invalid vault name: %q, must match [-a-zA-Z0-9]{3,24}", vaultName)
+func getVaultURL(vaultDNSSuffix, vaultName string) (vaultURL *string, err error) {
+	if err := v1alpha1.ValidateVaultName(vaultName); err != nil {
+		return nil, fmt.Errorf("invalid vault name: %q, %v", vaultName, err)
 	}
 
-	vaultDNSSuffixValue := azureEnvironment.KeyVaultDNSSuffix
-	vaultURI := "https://" + vaultName + "." + vaultDNSSuffixValue + "/"
+	vaultURI := "https://" + vaultName + "." + vaultDNSSuffix + "/"
 	return &vaultURI, nil
 }
 
-// getKeyvaultToken returns token for Keyvault endpoint
-func getKeyvaultToken(config *authConfig, env *azure.Environment) (authorizer autorest.Authorizer, err error) {
-	kvEndPoint := env.KeyVaultEndpoint
+// getKeyvaultToken returns token for the Keyvault or Managed HSM resource
+func getKeyvaultToken(config *authConfig, env *azure.Environment, resource string) (authorizer autorest.Authorizer, err error) {
+	kvEndPoint := resource
 	if '/' == kvEndPoint[len(kvEndPoint)-1] {
 		kvEndPoint = kvEndPoint[:len(kvEndPoint)-1]
 	}
@@ -173,32 +195,42 @@ func getKeyvaultToken(config *authConfig, env *azure.Environment) (authorizer au
 	if err != nil {
 		return nil, err
 	}
-	authorizer = autorest.NewBearerAuthorizer(servicePrincipalToken)
+	setTokenSender(servicePrincipalToken)
+	authorizer = &instrumentedAuthorizer{
+		Authorizer: autorest.NewBearerAuthorizer(servicePrincipalToken),
+		method:     config.authMethod(),
+	}
 	return authorizer, nil
 }
 
 // getServicePrincipalToken creates a new service principal token based on the configuration
 func getServicePrincipalToken(config *authConfig, env *azure.Environment, resource string) (adal.OAuthTokenProvider, error) {
-	oauthConfig, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, config.tenantID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create OAuth config, error: %v", err)
+	// using workload identity to access keyvault
+	if config.useWorkloadIdentity {
+		activeDirectoryEndpoint := env.ActiveDirectoryEndpoint
+		if authorityHost := os.Getenv(authorityHostEnv); authorityHost != "" {
+			activeDirectoryEndpoint = authorityHost
+		}
+		return getFederatedToken(config, activeDirectoryEndpoint, resource)
 	}
 
+	// using system or user-assigned managed identity to access keyvault, the
+	// token is always requested for the resource of the vault
 	if config.useManagedIdentity {
-		msiEndpoint, err := adal.GetMSIVMEndpoint()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get managed service identity endpoint, error: %v", err)
-		}
-		// using user-assigned managed identity to access keyvault
-		if len(config.userAssignedIdentityID) > 0 {
-			return adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, env.ServiceManagementEndpoint, config.userAssignedIdentityID)
-		}
-		// using system-assigned managed identity to access keyvault
-		return adal.NewServicePrincipalTokenFromMSI(msiEndpoint, resource)
+		return newMSITokenProvider(config, resource)
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, config.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OAuth config, error: %v", err)
 	}
 	// using service-principal to access the keyvault instance
 	if len(config.aadClientID) > 0 && len(config.aadClientSecret) > 0 {
 		return adal.NewServicePrincipalToken(*oauthConfig, config.aadClientID, config.aadClientSecret, resource)
 	}
+	// using service-principal with a client certificate to access the keyvault instance
+	if len(config.aadClientID) > 0 && config.aadClientCertificate != nil {
+		return adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, config.aadClientID, config.aadClientCertificate, config.aadClientKey, resource)
+	}
 	return nil, fmt.Errorf("no credentials provided for accessing keyvault")
 }
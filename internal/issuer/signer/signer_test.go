@@ -0,0 +1,118 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/aramase/azure-external-issuer/internal/testutil/fakekeyvault"
+)
+
+var testCreds = map[string][]byte{
+	tenantIDKey:        []byte("tenant"),
+	aadClientIDKey:     []byte("client-id"),
+	aadClientSecretKey: []byte("client-secret"),
+}
+
+// newTestServer starts a fake keyvault and sends the requests of new signers to it
+func newTestServer(t *testing.T) *fakekeyvault.Server {
+	t.Helper()
+	server := fakekeyvault.NewServer()
+	SetHTTPTransport(server.Transport())
+	t.Cleanup(func() {
+		SetHTTPTransport(nil)
+		server.Close()
+	})
+	return server
+}
+
+// newTestRequest returns a request for a certificate of the DNS name and the key
+// of its CSR
+func newTestRequest(t *testing.T, name, dnsName string) (Request, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: dnsName},
+		DNSNames: []string{dnsName},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Request{
+		Name: name,
+		CSR:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+	}, key
+}
+
+// newTestCA returns a self-signed CA certificate and its key
+func newTestCA(t *testing.T, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func parseSignedCertificate(t *testing.T, signed *SignedCertificate) (*x509.Certificate, *x509.Certificate) {
+	t.Helper()
+	block, _ := pem.Decode(signed.Certificate)
+	if block == nil {
+		t.Fatal("signed certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ = pem.Decode(signed.CA)
+	if block == nil {
+		t.Fatal("CA is not PEM encoded")
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, ca
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+var (
+	httpTransportMu sync.RWMutex
+	// httpTransport is the transport of keyvault and token requests, nil for
+	// the default transports of the autorest and adal clients
+	httpTransport http.RoundTripper
+)
+
+// SetHTTPTransport sets the transport of keyvault, Managed HSM and AAD token
+// requests, e.g. to send them to a fake keyvault in tests. It must be called
+// before the first signer is created, nil restores the default transports.
+func SetHTTPTransport(transport http.RoundTripper) {
+	httpTransportMu.Lock()
+	defer httpTransportMu.Unlock()
+	httpTransport = transport
+}
+
+func getHTTPTransport() http.RoundTripper {
+	httpTransportMu.RLock()
+	defer httpTransportMu.RUnlock()
+	return httpTransport
+}
+
+// newSender returns the sender of keyvault requests with the decorators applied
+func newSender(decorators ...autorest.SendDecorator) autorest.Sender {
+	if transport := getHTTPTransport(); transport != nil {
+		return autorest.DecorateSender(&http.Client{Transport: transport}, decorators...)
+	}
+	return autorest.CreateSender(decorators...)
+}
+
+// setTokenSender sends the token requests of the provider with the transport
+// set by SetHTTPTransport
+func setTokenSender(provider adal.OAuthTokenProvider) {
+	transport := getHTTPTransport()
+	if transport == nil {
+		return
+	}
+	if spt, ok := provider.(*adal.ServicePrincipalToken); ok {
+		spt.SetSender(&http.Client{Transport: transport})
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"fmt"
+	"time"
+
+	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+// month is the length of a month used to convert durations to the keyvault
+// validity in months
+const month = 30 * 24 * time.Hour
+
+// longestMonth is the length of the longest calendar month, keyvault
+// certificates are valid for calendar months from their issuance
+const longestMonth = 31 * 24 * time.Hour
+
+// validityInMonths converts the requested duration to the validity in months of
+// the keyvault certificate policy. Keyvault only supports whole months, so the
+// duration is rounded up to the next multiple of 30 days; the certificate is
+// never valid for less than the requested duration. A duration shorter than the
+// minimum validity of the issuer is raised to the minimum, a duration longer than
+// the maximum validity fails with ErrUnsupportedRequest.
+func validityInMonths(duration time.Duration, issuerSpec v1alpha1.IssuerSpec) (int32, error) {
+	if duration <= 0 {
+		duration = cmapi.DefaultCertificateDuration
+	}
+	months := int64(duration / month)
+	if duration%month != 0 {
+		months++
+	}
+	if issuerSpec.MinValidityInMonths != nil && months < int64(*issuerSpec.MinValidityInMonths) {
+		months = int64(*issuerSpec.MinValidityInMonths)
+	}
+	if issuerSpec.MaxValidityInMonths != nil && months > int64(*issuerSpec.MaxValidityInMonths) {
+		return 0, fmt.Errorf("%w: duration %s exceeds the maximum validity of %d months", ErrUnsupportedRequest, duration, *issuerSpec.MaxValidityInMonths)
+	}
+	return int32(months), nil
+}
+
+// certificateDuration returns the validity of certificates signed outside of a
+// keyvault certificate policy. The requested duration is used as is, within the
+// minimum and maximum validity of the issuer.
+func certificateDuration(duration time.Duration, issuerSpec v1alpha1.IssuerSpec) (time.Duration, error) {
+	if duration <= 0 {
+		duration = cmapi.DefaultCertificateDuration
+	}
+	if issuerSpec.MinValidityInMonths != nil && duration < time.Duration(*issuerSpec.MinValidityInMonths)*month {
+		duration = time.Duration(*issuerSpec.MinValidityInMonths) * month
+	}
+	if issuerSpec.MaxValidityInMonths != nil && duration > time.Duration(*issuerSpec.MaxValidityInMonths)*month {
+		return 0, fmt.Errorf("%w: duration %s exceeds the maximum validity of %d months", ErrUnsupportedRequest, duration, *issuerSpec.MaxValidityInMonths)
+	}
+	return duration, nil
+}
+
+// IssuedDuration returns the longest validity the backend of the issuer issues
+// a certificate requested for the duration with. Keyvault certificate policies
+// only have a validity in calendar months, so certificates of the KeyVault
+// backend are valid for up to 31 days per month. Durations the issuer refuses
+// are returned unchanged, signing fails for them.
+func IssuedDuration(issuerSpec v1alpha1.IssuerSpec, duration time.Duration) time.Duration {
+	if duration <= 0 {
+		duration = cmapi.DefaultCertificateDuration
+	}
+	if issuerSpec.BackendName() == v1alpha1.BackendKeyVault {
+		if months, err := validityInMonths(duration, issuerSpec); err == nil {
+			return time.Duration(months) * longestMonth
+		}
+		return duration
+	}
+	if issued, err := certificateDuration(duration, issuerSpec); err == nil {
+		return issued
+	}
+	return duration
+}
+
+// validateValidity checks the validity bounds of the issuer
+func validateValidity(issuerSpec v1alpha1.IssuerSpec) error {
+	if issuerSpec.MinValidityInMonths != nil && *issuerSpec.MinValidityInMonths < 1 {
+		return fmt.Errorf("minValidityInMonths must be at least 1, got %d", *issuerSpec.MinValidityInMonths)
+	}
+	if issuerSpec.MaxValidityInMonths != nil && *issuerSpec.MaxValidityInMonths < 1 {
+		return fmt.Errorf("maxValidityInMonths must be at least 1, got %d", *issuerSpec.MaxValidityInMonths)
+	}
+	if issuerSpec.MinValidityInMonths != nil && issuerSpec.MaxValidityInMonths != nil &&
+		*issuerSpec.MinValidityInMonths > *issuerSpec.MaxValidityInMonths {
+		return fmt.Errorf("minValidityInMonths %d is greater than maxValidityInMonths %d", *issuerSpec.MinValidityInMonths, *issuerSpec.MaxValidityInMonths)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"github.com/aramase/azure-external-issuer/api/v1alpha1"
+)
+
+func TestValidityInMonths(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		spec     v1alpha1.IssuerSpec
+		want     int32
+		wantErr  bool
+	}{
+		{
+			name: "default duration",
+			want: 3,
+		},
+		{
+			name:     "whole months",
+			duration: 2 * month,
+			want:     2,
+		},
+		{
+			name:     "rounded up",
+			duration: month + time.Hour,
+			want:     2,
+		},
+		{
+			name:     "raised to the minimum validity",
+			duration: 24 * time.Hour,
+			spec:     v1alpha1.IssuerSpec{MinValidityInMonths: to.Int32Ptr(6)},
+			want:     6,
+		},
+		{
+			name:     "maximum validity",
+			duration: 12 * month,
+			spec:     v1alpha1.IssuerSpec{MaxValidityInMonths: to.Int32Ptr(12)},
+			want:     12,
+		},
+		{
+			name:     "rounded up beyond the maximum validity",
+			duration: 12*month + time.Hour,
+			spec:     v1alpha1.IssuerSpec{MaxValidityInMonths: to.Int32Ptr(12)},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validityInMonths(tt.duration, tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedRequest) {
+					t.Fatalf("validityInMonths() error = %v, want %v", err, ErrUnsupportedRequest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validityInMonths() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("validityInMonths() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		spec     v1alpha1.IssuerSpec
+		want     time.Duration
+		wantErr  bool
+	}{
+		{
+			name: "default duration",
+			want: 90 * 24 * time.Hour,
+		},
+		{
+			name:     "requested duration",
+			duration: 36 * time.Hour,
+			want:     36 * time.Hour,
+		},
+		{
+			name:     "raised to the minimum validity",
+			duration: 24 * time.Hour,
+			spec:     v1alpha1.IssuerSpec{MinValidityInMonths: to.Int32Ptr(1)},
+			want:     month,
+		},
+		{
+			name:     "beyond the maximum validity",
+			duration: month + time.Hour,
+			spec:     v1alpha1.IssuerSpec{MaxValidityInMonths: to.Int32Ptr(1)},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := certificateDuration(tt.duration, tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsupportedRequest) {
+					t.Fatalf("certificateDuration() error = %v, want %v", err, ErrUnsupportedRequest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("certificateDuration() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("certificateDuration() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssuedDuration(t *testing.T) {
+	caSpec := v1alpha1.IssuerSpec{CACertificateName: "ca"}
+	tests := []struct {
+		name     string
+		duration time.Duration
+		spec     v1alpha1.IssuerSpec
+		want     time.Duration
+	}{
+		{
+			name: "default duration issued by keyvault",
+			want: 3 * longestMonth,
+		},
+		{
+			name:     "rounded up to calendar months by keyvault",
+			duration: month + time.Hour,
+			want:     2 * longestMonth,
+		},
+		{
+			name:     "raised to the minimum validity by keyvault",
+			duration: 24 * time.Hour,
+			spec:     v1alpha1.IssuerSpec{MinValidityInMonths: to.Int32Ptr(6)},
+			want:     6 * longestMonth,
+		},
+		{
+			name:     "beyond the maximum validity",
+			duration: 2 * month,
+			spec:     v1alpha1.IssuerSpec{MaxValidityInMonths: to.Int32Ptr(1)},
+			want:     2 * month,
+		},
+		{
+			name:     "requested duration signed with the CA key",
+			duration: 36 * time.Hour,
+			spec:     caSpec,
+			want:     36 * time.Hour,
+		},
+		{
+			name: "default duration signed with the CA key",
+			spec: caSpec,
+			want: 90 * 24 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IssuedDuration(tt.spec, tt.duration); got != tt.want {
+				t.Errorf("IssuedDuration() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics defines the Prometheus metrics of the issuer. They are
+// registered on the controller-runtime registry and served on the metrics
+// endpoint of the manager.
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "azure_issuer"
+
+var (
+	keyvaultRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "keyvault_requests_total",
+		Help:      "Number of keyvault requests by operation and status code.",
+	}, []string{"operation", "code"})
+
+	keyvaultRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "keyvault_request_duration_seconds",
+		Help:      "Latency of keyvault requests by operation and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "code"})
+
+	tokenFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_acquisition_failures_total",
+		Help:      "Number of failures to acquire an AAD token by authentication method.",
+	}, []string{"method"})
+
+	issuanceDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "certificate_issuance_duration_seconds",
+		Help:      "Time from the creation of a CertificateRequest to the issuance of its certificate.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	})
+
+	pendingOperations = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_operations",
+		Help:      "Number of CertificateRequests waiting for a keyvault certificate operation.",
+	})
+
+	issuerReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "issuer_ready",
+		Help:      "Whether the issuer is ready (1) or not (0).",
+	}, []string{"kind", "namespace", "name"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		keyvaultRequests,
+		keyvaultRequestDuration,
+		tokenFailures,
+		issuanceDuration,
+		pendingOperations,
+		issuerReady,
+	)
+}
+
+// ObserveKeyvaultRequest records a keyvault request. The code is zero if the
+// request failed without a response.
+func ObserveKeyvaultRequest(operation string, code int, duration time.Duration) {
+	codeLabel := strconv.Itoa(code)
+	keyvaultRequests.WithLabelValues(operation, codeLabel).Inc()
+	keyvaultRequestDuration.WithLabelValues(operation, codeLabel).Observe(duration.Seconds())
+}
+
+// TokenAcquisitionFailed records a failure to get an AAD token
+func TokenAcquisitionFailed(method string) {
+	tokenFailures.WithLabelValues(method).Inc()
+}
+
+// ObserveIssuance records the time a certificate took to be issued
+func ObserveIssuance(duration time.Duration) {
+	issuanceDuration.Observe(duration.Seconds())
+}
+
+// SetIssuerReady records the readiness of an issuer
+func SetIssuerReady(kind string, issuer types.NamespacedName, ready bool) {
+	value := 0.0
+	if ready {
+		value = 1
+	}
+	issuerReady.WithLabelValues(kind, issuer.Namespace, issuer.Name).Set(value)
+}
+
+// DeleteIssuer removes the readiness of a deleted issuer
+func DeleteIssuer(kind string, issuer types.NamespacedName) {
+	issuerReady.DeleteLabelValues(kind, issuer.Namespace, issuer.Name)
+}
+
+var (
+	pendingMu       sync.Mutex
+	pendingRequests = map[types.NamespacedName]struct{}{}
+)
+
+// SetPending records whether the CertificateRequest is waiting for a keyvault
+// certificate operation
+func SetPending(certificateRequest types.NamespacedName, pending bool) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if pending {
+		pendingRequests[certificateRequest] = struct{}{}
+	} else {
+		delete(pendingRequests, certificateRequest)
+	}
+	pendingOperations.Set(float64(len(pendingRequests)))
+}
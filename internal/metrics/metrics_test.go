@@ -0,0 +1,110 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestObserveKeyvaultRequest(t *testing.T) {
+	ok := keyvaultRequests.WithLabelValues("CreateCertificate", "202")
+	throttled := keyvaultRequests.WithLabelValues("CreateCertificate", "429")
+	failed := keyvaultRequests.WithLabelValues("CreateCertificate", "0")
+	before := []float64{testutil.ToFloat64(ok), testutil.ToFloat64(throttled), testutil.ToFloat64(failed)}
+
+	ObserveKeyvaultRequest("CreateCertificate", 202, time.Second)
+	ObserveKeyvaultRequest("CreateCertificate", 202, time.Second)
+	ObserveKeyvaultRequest("CreateCertificate", 429, time.Second)
+	ObserveKeyvaultRequest("CreateCertificate", 0, time.Second)
+
+	for i, tc := range []struct {
+		code string
+		want float64
+	}{
+		{code: "202", want: 2},
+		{code: "429", want: 1},
+		{code: "0", want: 1},
+	} {
+		got := testutil.ToFloat64(keyvaultRequests.WithLabelValues("CreateCertificate", tc.code)) - before[i]
+		if got != tc.want {
+			t.Errorf("keyvault_requests_total{code=%q} increased by %v, want %v", tc.code, got, tc.want)
+		}
+	}
+	if got := testutil.CollectAndCount(keyvaultRequestDuration); got < 3 {
+		t.Errorf("keyvault_request_duration_seconds has %d series, want at least 3", got)
+	}
+}
+
+func TestTokenAcquisitionFailed(t *testing.T) {
+	before := testutil.ToFloat64(tokenFailures.WithLabelValues("workload_identity"))
+	TokenAcquisitionFailed("workload_identity")
+	if got := testutil.ToFloat64(tokenFailures.WithLabelValues("workload_identity")) - before; got != 1 {
+		t.Errorf("token_acquisition_failures_total increased by %v, want 1", got)
+	}
+}
+
+func TestSetPending(t *testing.T) {
+	first := types.NamespacedName{Namespace: "default", Name: "first"}
+	second := types.NamespacedName{Namespace: "default", Name: "second"}
+	steps := []struct {
+		name    string
+		request types.NamespacedName
+		pending bool
+		want    float64
+	}{
+		{name: "first pending", request: first, pending: true, want: 1},
+		{name: "first polled again", request: first, pending: true, want: 1},
+		{name: "second pending", request: second, pending: true, want: 2},
+		{name: "first issued", request: first, pending: false, want: 1},
+		{name: "first deleted", request: first, pending: false, want: 1},
+		{name: "second failed", request: second, pending: false, want: 0},
+	}
+	for _, step := range steps {
+		SetPending(step.request, step.pending)
+		if got := testutil.ToFloat64(pendingOperations); got != step.want {
+			t.Errorf("%s: pending_operations = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestIssuerReady(t *testing.T) {
+	issuer := types.NamespacedName{Namespace: "default", Name: "issuer"}
+	SetIssuerReady("Issuer", issuer, true)
+	if got := testutil.ToFloat64(issuerReady.WithLabelValues("Issuer", "default", "issuer")); got != 1 {
+		t.Errorf("issuer_ready = %v, want 1", got)
+	}
+	SetIssuerReady("Issuer", issuer, false)
+	if got := testutil.ToFloat64(issuerReady.WithLabelValues("Issuer", "default", "issuer")); got != 0 {
+		t.Errorf("issuer_ready = %v, want 0", got)
+	}
+
+	DeleteIssuer("Issuer", issuer)
+	if issuerReady.DeleteLabelValues("Issuer", "default", "issuer") {
+		t.Errorf("issuer_ready of the deleted issuer was not removed")
+	}
+}
+
+func TestObserveIssuance(t *testing.T) {
+	ObserveIssuance(90 * time.Second)
+	if got := testutil.CollectAndCount(issuanceDuration); got != 1 {
+		t.Errorf("certificate_issuance_duration_seconds has %d series, want 1", got)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakekeyvault is an in-process stand-in for Azure Key Vault and the
+// Azure AD token endpoints, so signers and controllers can be tested offline.
+//
+// The server serves the keyvault certificate, issuer, secret and key
+// operations used by the signers for any number of vaults, the AAD client
+// credentials token endpoint and the managed identity token endpoint. All
+// requests are sent to the server by the transport returned by Transport,
+// which keeps the original host so the vault is selected by the first label
+// of the host name, e.g. kv1 for https://kv1.vault.azure.net.
+package fakekeyvault
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessToken is the access token issued by the fake AAD. The vaults reject
+// requests without it.
+const AccessToken = "fake-access-token"
+
+// Fault makes the server fail or delay the requests it matches
+type Fault struct {
+	// Method matches the HTTP method of requests, any method if empty
+	Method string
+	// Path matches the URL path of requests with path.Match, e.g.
+	// "/certificates/*/create" or "/*/oauth2/token", any path if empty
+	Path string
+	// Delay delays the matching requests
+	Delay time.Duration
+	// StatusCode fails the matching requests with the status code. Requests
+	// are served normally after the delay if it is zero.
+	StatusCode int
+	// Code is the error code of the response, derived from the status code
+	// if empty
+	Code string
+	// InnerCode is the inner error code of the response, e.g. ForbiddenByPolicy
+	InnerCode string
+	// RetryAfter is sent in the Retry-After header of the response, rounded
+	// up to whole seconds
+	RetryAfter time.Duration
+	// Times is the number of requests the fault applies to, all requests if zero
+	Times int
+}
+
+// fault is an injected Fault and the number of requests it still applies to
+type fault struct {
+	Fault
+	remaining int
+}
+
+// Server is a fake keyvault and AAD server
+type Server struct {
+	srv *httptest.Server
+
+	mu             sync.Mutex
+	vaults         map[string]*Vault
+	faults         []*fault
+	requests       []string
+	tokenResources []string
+	tokenForms     []url.Values
+}
+
+// NewServer starts a fake keyvault and AAD server. It must be closed with Close.
+func NewServer() *Server {
+	s := &Server{
+		vaults: map[string]*Vault{},
+	}
+	s.srv = httptest.NewTLSServer(s)
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Transport returns a transport that sends all requests to the server. It
+// trusts the certificate of the server and keeps the original host of the
+// requests, so it can be used for the keyvault, AAD and IMDS hosts alike.
+func (s *Server) Transport() http.RoundTripper {
+	return &redirectTransport{
+		addr: s.srv.Listener.Addr().String(),
+		next: s.srv.Client().Transport,
+	}
+}
+
+// AddVault creates an empty vault, or a Managed HSM, with the name
+func (s *Server) AddVault(name string) *Vault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := newVault()
+	s.vaults[strings.ToLower(name)] = v
+	return v
+}
+
+// Vault returns the vault with the name, nil if it doesn't exist
+func (s *Server) Vault(name string) *Vault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.vaults[strings.ToLower(name)]
+}
+
+// Inject adds a fault. Faults are matched in the order they were injected.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f, remaining: f.Times})
+}
+
+// ClearFaults removes all faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the method and path of all requests served so far, e.g.
+// "POST /certificates/cert/create"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// TokenResources returns the resources of all token requests served so far
+func (s *Server) TokenResources() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.tokenResources...)
+}
+
+// TokenForms returns the form values of all token requests served so far,
+// including the query of managed identity token requests
+func (s *Server) TokenForms() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]url.Values(nil), s.tokenForms...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f := s.record(r)
+	if f != nil {
+		if f.Delay > 0 {
+			select {
+			case <-time.After(f.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.StatusCode != 0 {
+			if f.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(f.RetryAfter.Seconds()))))
+			}
+			writeError(w, f.StatusCode, f.Code, f.InnerCode, "injected fault")
+			return
+		}
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/oauth2/token") {
+		s.serveToken(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+AccessToken {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "", "missing or invalid access token")
+		return
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	name := strings.SplitN(host, ".", 2)[0]
+	v := s.Vault(name)
+	if v == nil {
+		writeError(w, http.StatusNotFound, "VaultNotFound", "", fmt.Sprintf("vault %s not found", name))
+		return
+	}
+	v.serve(w, r, "https://"+host+"/")
+}
+
+// record records the request and returns the fault it matches, if any
+func (s *Server) record(r *http.Request) *fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	for _, f := range s.faults {
+		if f.Times > 0 && f.remaining == 0 {
+			continue
+		}
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if f.Path != "" {
+			if ok, _ := path.Match(f.Path, r.URL.Path); !ok {
+				continue
+			}
+		}
+		f.remaining--
+		return f
+	}
+	return nil
+}
+
+// serveToken issues AccessToken for AAD client credentials and managed
+// identity token requests
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "", err.Error())
+		return
+	}
+	resource := r.Form.Get("resource")
+	s.mu.Lock()
+	s.tokenResources = append(s.tokenResources, resource)
+	s.tokenForms = append(s.tokenForms, r.Form)
+	s.mu.Unlock()
+
+	now := time.Now()
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": AccessToken,
+		"token_type":   "Bearer",
+		"resource":     resource,
+		"expires_in":   "3600",
+		"expires_on":   strconv.FormatInt(now.Add(time.Hour).Unix(), 10),
+		"not_before":   strconv.FormatInt(now.Unix(), 10),
+	})
+}
+
+// redirectTransport sends all requests to the server address
+type redirectTransport struct {
+	addr string
+	next http.RoundTripper
+}
+
+func (t *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+	r.URL.Scheme = "https"
+	r.URL.Host = t.addr
+	return t.next.RoundTrip(r)
+}
+
+// errorBody is the error of a keyvault error response
+type errorBody struct {
+	Code       string     `json:"code"`
+	Message    string     `json:"message"`
+	InnerError *errorBody `json:"innererror,omitempty"`
+}
+
+// writeError writes a keyvault error response
+func writeError(w http.ResponseWriter, statusCode int, code, innerCode, message string) {
+	if code == "" {
+		code = strings.ReplaceAll(http.StatusText(statusCode), " ", "")
+	}
+	body := errorBody{Code: code, Message: message}
+	if innerCode != "" {
+		body.InnerError = &errorBody{Code: innerCode}
+	}
+	if statusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer authorization="https://login.microsoftonline.com/tenant", resource="https://vault.azure.net"`)
+	}
+	writeJSON(w, statusCode, struct {
+		Error errorBody `json:"error"`
+	}{Error: body})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
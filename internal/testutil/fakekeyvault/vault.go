@@ -0,0 +1,674 @@
+/*
+Copyright 2021 Anish Ramasekar.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakekeyvault
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	kv "github.com/Azure/azure-sdk-for-go/services/keyvault/v7.0/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	// SelfIssuer is the issuer name of self-signed certificates
+	SelfIssuer = "Self"
+
+	statusInProgress = "inProgress"
+	statusCompleted  = "completed"
+	statusFailed     = "failed"
+)
+
+// Vault is a fake vault holding certificate issuers, certificates and keys
+type Vault struct {
+	mu           sync.Mutex
+	issuers      map[string]*issuer
+	certificates map[string]*certificate
+	keys         map[string][]*keyVersion
+	issueDelay   time.Duration
+	failure      string
+}
+
+// issuer is a certificate issuer of the vault backed by a local CA
+type issuer struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// certificate is a certificate of the vault with its versions, latest last,
+// and its last operation
+type certificate struct {
+	versions  []*certificateVersion
+	operation *operation
+}
+
+type certificateVersion struct {
+	version string
+	cert    *x509.Certificate
+	chain   []*x509.Certificate
+	key     *keyVersion
+}
+
+type keyVersion struct {
+	version string
+	signer  crypto.Signer
+}
+
+// operation is a certificate operation. Operations complete once the issue
+// delay has passed.
+type operation struct {
+	requestID  string
+	issuerName string
+	issuer     *issuer
+	template   *x509.Certificate
+	key        *keyVersion
+	csr        []byte
+	readyAt    time.Time
+	failure    string
+	status     string
+}
+
+func newVault() *Vault {
+	return &Vault{
+		issuers:      map[string]*issuer{},
+		certificates: map[string]*certificate{},
+		keys:         map[string][]*keyVersion{},
+	}
+}
+
+// AddIssuer adds a certificate issuer signing with a new CA and returns the
+// CA certificate
+func (v *Vault) AddIssuer(name string) *x509.Certificate {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	cert, key := newCA(name)
+	v.issuers[name] = &issuer{cert: cert, key: key}
+	return cert
+}
+
+// AddCACertificate adds a self-signed CA certificate along with its key and
+// returns the certificate. The key can be used by the KeyVaultCA backend
+// through the certificate, or directly by the ManagedHSM backend.
+func (v *Vault) AddCACertificate(name string) *x509.Certificate {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	cert, signer := newCA(name)
+	key := v.addKey(name, signer)
+	v.addCertificateVersion(name, &certificateVersion{
+		version: key.version,
+		cert:    cert,
+		key:     key,
+	})
+	return cert
+}
+
+// Certificate returns the latest version of the certificate, nil if the
+// certificate has not been issued
+func (v *Vault) Certificate(name string) *x509.Certificate {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.certificates[name]
+	if !ok || len(c.versions) == 0 {
+		return nil
+	}
+	return c.versions[len(c.versions)-1].cert
+}
+
+// SetIssueDelay sets how long certificate operations of the issuers of the
+// vault stay in progress
+func (v *Vault) SetIssueDelay(delay time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.issueDelay = delay
+}
+
+// SetOperationFailure makes new certificate operations fail with the message,
+// an empty message lets them succeed again
+func (v *Vault) SetOperationFailure(message string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.failure = message
+}
+
+// serve serves a keyvault request, baseURL is the vault URL as seen by the client
+func (v *Vault) serve(w http.ResponseWriter, r *http.Request, baseURL string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	get, post := r.Method == http.MethodGet, r.Method == http.MethodPost
+	switch {
+	case get && len(segments) == 1 && segments[0] == "certificates":
+		v.listCertificates(w, baseURL)
+	case get && len(segments) == 3 && segments[0] == "certificates" && segments[1] == "issuers":
+		v.getIssuer(w, baseURL, segments[2])
+	case post && len(segments) == 3 && segments[0] == "certificates" && segments[2] == "create":
+		v.createCertificate(w, r, baseURL, segments[1])
+	case get && len(segments) == 3 && segments[0] == "certificates" && segments[2] == "pending":
+		v.getOperation(w, baseURL, segments[1])
+	case get && (len(segments) == 2 || len(segments) == 3) && segments[0] == "certificates":
+		v.getCertificate(w, baseURL, segments[1], version(segments))
+	case get && (len(segments) == 2 || len(segments) == 3) && segments[0] == "secrets":
+		v.getSecret(w, baseURL, segments[1], version(segments))
+	case get && (len(segments) == 2 || len(segments) == 3) && segments[0] == "keys":
+		v.getKey(w, baseURL, segments[1], version(segments))
+	case post && len(segments) == 4 && segments[0] == "keys" && segments[3] == "sign":
+		v.sign(w, r, baseURL, segments[1], segments[2])
+	default:
+		writeError(w, http.StatusNotFound, "NotFound", "", fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+	}
+}
+
+// version returns the version segment of an object path, empty for the latest
+func version(segments []string) string {
+	if len(segments) == 3 {
+		return segments[2]
+	}
+	return ""
+}
+
+func (v *Vault) listCertificates(w http.ResponseWriter, baseURL string) {
+	items := []map[string]string{}
+	for name, c := range v.certificates {
+		if len(c.versions) > 0 {
+			items = append(items, map[string]string{"id": baseURL + "certificates/" + name})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": items})
+}
+
+func (v *Vault) getIssuer(w http.ResponseWriter, baseURL, name string) {
+	if _, ok := v.issuers[name]; !ok {
+		writeError(w, http.StatusNotFound, "CertificateIssuerNotFound", "", fmt.Sprintf("issuer %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"id":       baseURL + "certificates/issuers/" + name,
+		"provider": "fake",
+	})
+}
+
+func (v *Vault) createCertificate(w http.ResponseWriter, r *http.Request, baseURL, name string) {
+	var params kv.CertificateCreateParameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, "BadParameter", "", err.Error())
+		return
+	}
+	policy := params.CertificatePolicy
+	if policy == nil || policy.IssuerParameters == nil || policy.X509CertificateProperties == nil {
+		writeError(w, http.StatusBadRequest, "BadParameter", "", "policy with issuer and x509 properties is required")
+		return
+	}
+	issuerName := to.String(policy.IssuerParameters.Name)
+	var iss *issuer
+	if issuerName != SelfIssuer {
+		var ok bool
+		if iss, ok = v.issuers[issuerName]; !ok {
+			writeError(w, http.StatusBadRequest, "BadParameter", "", fmt.Sprintf("issuer %s not found", issuerName))
+			return
+		}
+	}
+	if c, ok := v.certificates[name]; ok && c.operation != nil && c.operation.status == statusInProgress {
+		writeError(w, http.StatusConflict, "Conflict", "", fmt.Sprintf("certificate %s has an operation in progress", name))
+		return
+	}
+	signer, err := generateKey(policy.KeyProperties)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BadParameter", "", err.Error())
+		return
+	}
+	template, err := certificateTemplate(policy.X509CertificateProperties)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BadParameter", "", err.Error())
+		return
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        template.Subject,
+		DNSNames:       template.DNSNames,
+		EmailAddresses: template.EmailAddresses,
+	}, signer)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalError", "", err.Error())
+		return
+	}
+
+	op := &operation{
+		requestID:  newID(),
+		issuerName: issuerName,
+		issuer:     iss,
+		template:   template,
+		key:        v.addKey(name, signer),
+		csr:        csr,
+		readyAt:    time.Now().Add(v.issueDelay),
+		failure:    v.failure,
+		status:     statusInProgress,
+	}
+	c := v.certificate(name)
+	c.operation = op
+	writeJSON(w, http.StatusAccepted, operationResponse(baseURL, name, op))
+}
+
+func (v *Vault) getOperation(w http.ResponseWriter, baseURL, name string) {
+	c, ok := v.certificates[name]
+	if !ok || c.operation == nil {
+		writeError(w, http.StatusNotFound, "PendingCertificateNotFound", "", fmt.Sprintf("pending certificate %s not found", name))
+		return
+	}
+	op := c.operation
+	if op.status == statusInProgress && !time.Now().Before(op.readyAt) {
+		v.completeOperation(name, op)
+	}
+	writeJSON(w, http.StatusOK, operationResponse(baseURL, name, op))
+}
+
+// completeOperation issues the certificate of the operation with its issuer
+func (v *Vault) completeOperation(name string, op *operation) {
+	if op.failure != "" {
+		op.status = statusFailed
+		return
+	}
+	parent, parentKey := op.template, op.key.signer
+	var chain []*x509.Certificate
+	if op.issuer != nil {
+		parent, parentKey = op.issuer.cert, op.issuer.key
+		chain = []*x509.Certificate{op.issuer.cert}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, op.template, parent, op.key.signer.Public(), parentKey)
+	if err != nil {
+		op.status, op.failure = statusFailed, err.Error()
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		op.status, op.failure = statusFailed, err.Error()
+		return
+	}
+	v.addCertificateVersion(name, &certificateVersion{
+		version: op.key.version,
+		cert:    cert,
+		chain:   chain,
+		key:     op.key,
+	})
+	op.status = statusCompleted
+}
+
+func (v *Vault) getCertificate(w http.ResponseWriter, baseURL, name, version string) {
+	cv := v.certificateVersion(name, version)
+	if cv == nil {
+		writeError(w, http.StatusNotFound, "CertificateNotFound", "", fmt.Sprintf("certificate %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, certificateResponse(baseURL, name, cv))
+}
+
+// getSecret returns the PEM content of the secret backing a certificate
+func (v *Vault) getSecret(w http.ResponseWriter, baseURL, name, version string) {
+	cv := v.certificateVersion(name, version)
+	if cv == nil {
+		writeError(w, http.StatusNotFound, "SecretNotFound", "", fmt.Sprintf("secret %s not found", name))
+		return
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cv.key.signer)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalError", "", err.Error())
+		return
+	}
+	value := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	for _, cert := range append([]*x509.Certificate{cv.cert}, cv.chain...) {
+		value = append(value, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":          baseURL + "secrets/" + name + "/" + cv.version,
+		"kid":         baseURL + "keys/" + name + "/" + cv.version,
+		"value":       string(value),
+		"contentType": "application/x-pem-file",
+		"managed":     true,
+	})
+}
+
+func (v *Vault) getKey(w http.ResponseWriter, baseURL, name, version string) {
+	key := v.keyVersion(name, version)
+	if key == nil {
+		writeError(w, http.StatusNotFound, "KeyNotFound", "", fmt.Sprintf("key %s not found", name))
+		return
+	}
+	jwk := map[string]interface{}{
+		"kid":     baseURL + "keys/" + name + "/" + key.version,
+		"key_ops": []string{"sign", "verify"},
+	}
+	switch pub := key.signer.Public().(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(padLeft(pub.X.Bytes(), size))
+		jwk["y"] = base64.RawURLEncoding.EncodeToString(padLeft(pub.Y.Bytes(), size))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"key":        jwk,
+		"attributes": map[string]bool{"enabled": true},
+	})
+}
+
+// sign signs a digest with the key like the keyvault sign operation
+func (v *Vault) sign(w http.ResponseWriter, r *http.Request, baseURL, name, version string) {
+	var params kv.KeySignParameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, "BadParameter", "", err.Error())
+		return
+	}
+	key := v.keyVersion(name, version)
+	if key == nil {
+		writeError(w, http.StatusNotFound, "KeyNotFound", "", fmt.Sprintf("key %s not found", name))
+		return
+	}
+	digest, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(to.String(params.Value), "="))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BadParameter", "", err.Error())
+		return
+	}
+	signature, err := signDigest(key.signer, params.Algorithm, digest)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BadParameter", "", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"kid":   baseURL + "keys/" + name + "/" + key.version,
+		"value": base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
+
+// signDigest signs the digest with the keyvault signature algorithm. ECDSA
+// signatures are returned in the raw r||s form like keyvault does.
+func signDigest(signer crypto.Signer, algorithm kv.JSONWebKeySignatureAlgorithm, digest []byte) ([]byte, error) {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	alg := string(algorithm)
+	if len(alg) != 5 {
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok || len(digest) != hash.Size() {
+		return nil, fmt.Errorf("invalid digest for algorithm %s", alg)
+	}
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+		case "PS":
+			return rsa.SignPSS(rand.Reader, key, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PrivateKey:
+		if alg[:2] == "ES" {
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+			if err != nil {
+				return nil, err
+			}
+			size := (key.Curve.Params().BitSize + 7) / 8
+			return append(padLeft(r.Bytes(), size), padLeft(s.Bytes(), size)...), nil
+		}
+	}
+	return nil, fmt.Errorf("algorithm %s doesn't match the key type", alg)
+}
+
+// certificate returns the certificate with the name, creating it if needed
+func (v *Vault) certificate(name string) *certificate {
+	c, ok := v.certificates[name]
+	if !ok {
+		c = &certificate{}
+		v.certificates[name] = c
+	}
+	return c
+}
+
+func (v *Vault) addCertificateVersion(name string, cv *certificateVersion) {
+	c := v.certificate(name)
+	c.versions = append(c.versions, cv)
+}
+
+// certificateVersion returns a version of the certificate, the latest one if
+// version is empty
+func (v *Vault) certificateVersion(name, version string) *certificateVersion {
+	c, ok := v.certificates[name]
+	if !ok || len(c.versions) == 0 {
+		return nil
+	}
+	if version == "" {
+		return c.versions[len(c.versions)-1]
+	}
+	for _, cv := range c.versions {
+		if cv.version == version {
+			return cv
+		}
+	}
+	return nil
+}
+
+func (v *Vault) addKey(name string, signer crypto.Signer) *keyVersion {
+	key := &keyVersion{version: newID(), signer: signer}
+	v.keys[name] = append(v.keys[name], key)
+	return key
+}
+
+// keyVersion returns a version of the key, the latest one if version is empty
+func (v *Vault) keyVersion(name, version string) *keyVersion {
+	versions := v.keys[name]
+	if len(versions) == 0 {
+		return nil
+	}
+	if version == "" {
+		return versions[len(versions)-1]
+	}
+	for _, key := range versions {
+		if key.version == version {
+			return key
+		}
+	}
+	return nil
+}
+
+func operationResponse(baseURL, name string, op *operation) map[string]interface{} {
+	resp := map[string]interface{}{
+		"id":         baseURL + "certificates/" + name + "/pending",
+		"issuer":     map[string]string{"name": op.issuerName},
+		"csr":        op.csr,
+		"status":     op.status,
+		"request_id": op.requestID,
+	}
+	switch op.status {
+	case statusCompleted:
+		resp["target"] = baseURL + "certificates/" + name
+	case statusFailed:
+		resp["status_details"] = op.failure
+		resp["error"] = errorBody{Code: "CertificateIssuanceFailed", Message: op.failure}
+	}
+	return resp
+}
+
+func certificateResponse(baseURL, name string, cv *certificateVersion) map[string]interface{} {
+	thumbprint := sha1.Sum(cv.cert.Raw)
+	return map[string]interface{}{
+		"id":         baseURL + "certificates/" + name + "/" + cv.version,
+		"kid":        baseURL + "keys/" + name + "/" + cv.key.version,
+		"sid":        baseURL + "secrets/" + name + "/" + cv.version,
+		"x5t":        base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		"cer":        cv.cert.Raw,
+		"attributes": map[string]bool{"enabled": true},
+	}
+}
+
+// generateKey generates the key pair of a certificate policy
+func generateKey(props *kv.KeyProperties) (crypto.Signer, error) {
+	kty, size, curve := "RSA", int32(2048), "P-256"
+	if props != nil {
+		if props.KeyType != "" {
+			kty = string(props.KeyType)
+		}
+		if props.KeySize != nil {
+			size = *props.KeySize
+		}
+		if props.Curve != "" {
+			curve = string(props.Curve)
+		}
+	}
+	switch kty {
+	case "RSA", "RSA-HSM":
+		return rsa.GenerateKey(rand.Reader, int(size))
+	case "EC", "EC-HSM":
+		curves := map[string]elliptic.Curve{
+			"P-256": elliptic.P256(),
+			"P-384": elliptic.P384(),
+			"P-521": elliptic.P521(),
+		}
+		c, ok := curves[curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", curve)
+		}
+		return ecdsa.GenerateKey(c, rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", kty)
+	}
+}
+
+// certificateTemplate returns the certificate template for the x509 properties
+// of a certificate policy
+func certificateTemplate(props *kv.X509CertificateProperties) (*x509.Certificate, error) {
+	subject, err := parseSubject(to.String(props.Subject))
+	if err != nil {
+		return nil, err
+	}
+	months := 12
+	if props.ValidityInMonths != nil {
+		months = int(*props.ValidityInMonths)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      subject,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.AddDate(0, months, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if sans := props.SubjectAlternativeNames; sans != nil {
+		if sans.DNSNames != nil {
+			template.DNSNames = *sans.DNSNames
+		}
+		if sans.Emails != nil {
+			template.EmailAddresses = *sans.Emails
+		}
+	}
+	return template, nil
+}
+
+// parseSubject parses a subject of the form CN=name,O=org
+func parseSubject(subject string) (pkix.Name, error) {
+	var name pkix.Name
+	for _, attr := range strings.Split(subject, ",") {
+		parts := strings.SplitN(strings.TrimSpace(attr), "=", 2)
+		if len(parts) != 2 {
+			return name, fmt.Errorf("invalid subject %q", subject)
+		}
+		switch value := parts[1]; strings.ToUpper(parts[0]) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "ST", "S":
+			name.Province = append(name.Province, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		default:
+			return name, fmt.Errorf("unsupported attribute %s in subject %q", parts[0], subject)
+		}
+	}
+	return name, nil
+}
+
+// newCA returns a self-signed CA certificate and its key
+func newCA(name string) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: name + " fake CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return cert, key
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func serialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return serial
+}
+
+// newID returns a random object version or request ID
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
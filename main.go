@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	cmapi "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -32,6 +33,7 @@ import (
 
 	azureissuerv1alpha1 "github.com/aramase/azure-external-issuer/api/v1alpha1"
 	"github.com/aramase/azure-external-issuer/internal/controllers"
+	"github.com/aramase/azure-external-issuer/internal/issuer/signer"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -57,6 +59,10 @@ func main() {
 	var enableLeaderElection bool
 	var clusterResourceNamespace string
 	var disableApprovedCheck bool
+	var clusterID string
+	var vaultQPS float64
+	var vaultBurst int
+	var enableWebhooks bool
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
@@ -65,10 +71,24 @@ func main() {
 	flag.StringVar(&clusterResourceNamespace, "cluster-resource-namespace", "", "The namespace for secrets in which cluster-scoped resources are found.")
 	flag.BoolVar(&disableApprovedCheck, "disable-approved-check", false,
 		"Disables waiting for CertificateRequests to have an approved condition before signing.")
+	flag.StringVar(&clusterID, "cluster-id", "", "The ID of the cluster, without dots. It prefixes the default keyvault certificate names and is available as .ClusterID in certificate name templates.")
+	flag.Float64Var(&vaultQPS, "keyvault-qps", signer.DefaultVaultQPS, "The maximum rate of requests sent to each vault.")
+	flag.IntVar(&vaultBurst, "keyvault-burst", signer.DefaultVaultBurst, "The maximum burst of requests sent to each vault.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", true,
+		"Serve the Issuer and ClusterIssuer admission webhooks. Requires the serving certificate of the webhook server.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	// the cluster ID is separated from the namespace by a dot in the default
+	// certificate names, a dot in the ID would make the names ambiguous
+	if strings.Contains(clusterID, ".") {
+		setupLog.Error(fmt.Errorf("invalid cluster ID %q", clusterID), "--cluster-id must not contain dots")
+		os.Exit(1)
+	}
+
+	signer.SetVaultRateLimit(vaultQPS, vaultBurst)
+
 	if clusterResourceNamespace == "" {
 		var err error
 		clusterResourceNamespace, err = getInClusterNamespace()
@@ -94,11 +114,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	// signers are shared by the controllers so each issuer authenticates once
+	signerCache := signer.NewCache()
+
 	if err = (&controllers.IssuerReconciler{
 		Kind:                     "Issuer",
 		ClusterResourceNamespace: clusterResourceNamespace,
 		Client:                   mgr.GetClient(),
 		Scheme:                   mgr.GetScheme(),
+		HealthCheckerBuilder:     signer.NewHealthChecker,
+		SignerCache:              signerCache,
+		Recorder:                 mgr.GetEventRecorderFor("issuer-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Issuer")
 		os.Exit(1)
@@ -108,6 +134,9 @@ func main() {
 		ClusterResourceNamespace: clusterResourceNamespace,
 		Client:                   mgr.GetClient(),
 		Scheme:                   mgr.GetScheme(),
+		HealthCheckerBuilder:     signer.NewHealthChecker,
+		SignerCache:              signerCache,
+		Recorder:                 mgr.GetEventRecorderFor("clusterissuer-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterIssuer")
 		os.Exit(1)
@@ -118,10 +147,24 @@ func main() {
 		ClusterResourceNamespace: clusterResourceNamespace,
 		Clock:                    clock.RealClock{},
 		CheckApprovedCondition:   disableApprovedCheck,
+		ClusterID:                clusterID,
+		SignerBuilder:            signer.NewSigner,
+		SignerCache:              signerCache,
+		Recorder:                 mgr.GetEventRecorderFor("certificaterequests-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "CertificateRequest")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err = (&azureissuerv1alpha1.Issuer{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Issuer")
+			os.Exit(1)
+		}
+		if err = (&azureissuerv1alpha1.ClusterIssuer{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterIssuer")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")